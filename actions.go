@@ -0,0 +1,143 @@
+package urest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+type (
+	// ActionResult describes the response to an action. Status defaults to
+	// 200 if there is a Body, 201 if there is a Resource and 204 otherwise.
+	// Location defaults to the URL of Resource.
+	ActionResult struct {
+		Status   int
+		Body     interface{}
+		Location string
+		Resource Resource
+		Header   http.Header
+	}
+
+	// ActionResource is implemented by resources whose actions return more
+	// than success or failure. The Handler prefers DoAction over Do.
+	ActionResource interface {
+		DoAction(string, *http.Request) (*ActionResult, error)
+	}
+)
+
+// TypedAction adapts f to an action taking arguments of type A, decoded from
+// the request body and validated before f is called. An empty body leaves
+// the arguments zero.
+func TypedAction[A any](f func(A, *http.Request) (*ActionResult, error)) func(*http.Request) (*ActionResult, error) {
+	if err := checkRules(new(A)); err != nil {
+		panic(fmt.Sprintf("Action arguments have invalid validation rules: %v", err))
+	}
+
+	return func(r *http.Request) (*ActionResult, error) {
+		var args A
+		body := []byte{}
+		if r.Body != nil {
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, WrapHTTPError(http.StatusBadRequest, "invalid_body", err)
+			}
+			body = b
+		}
+		if len(body) > 0 {
+			if err := decodeBody(r.Header.Get("Content-Type"), body, &args); err != nil {
+				return nil, err
+			}
+		}
+		if err := Validate(&args); err != nil {
+			return nil, err
+		}
+		return f(args, r)
+	}
+}
+
+func (h *Handler) doAction(res Resource, action string, w http.ResponseWriter, r *http.Request) {
+	if aa, ok := res.(AsyncActionResource); ok && index(aa.AllowedAsyncActions(), action) != -1 {
+		if f, err := aa.AsyncAction(action, r); err != nil {
+			h.reportError(w, r, res, err)
+		} else {
+			h.submitJob(res, f, w, r)
+		}
+		return
+	}
+
+	ar, ok := res.(ActionResource)
+	if d := defaultImpl(res); d != nil && d.ResultActions[action] == nil {
+		// types embedding DefaultResourceImpl may override Do
+		ok = false
+	}
+	if !ok {
+		if e := res.Do(action, r); e != nil {
+			h.reportError(w, r, res, e)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	result, err := ar.DoAction(action, r)
+	if err != nil {
+		h.reportError(w, r, res, err)
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body := []byte(nil)
+	if result.Body != nil {
+		def := EncoderFor(res.ContentType())
+		if def == nil {
+			def = EncoderFor(CONTENT_TYPE_JSON)
+		}
+		enc, err := NegotiateEncoder(r, def)
+		if err != nil {
+			h.reportError(w, r, res, err)
+			return
+		}
+		if body, err = enc.Marshal(result.Body); err != nil {
+			h.reportError(w, r, res, err)
+			return
+		}
+		w.Header().Set("Content-Type", enc.ContentType())
+		w.Header().Set("Vary", "Accept")
+	}
+
+	for k, v := range result.Header {
+		w.Header()[k] = v
+	}
+
+	loc := result.Location
+	if loc == "" && result.Resource != nil {
+		loc = RelativeURL(h.prefix, result.Resource).String()
+	}
+	if loc != "" {
+		w.Header().Set("Location", loc)
+	}
+
+	status := result.Status
+	switch {
+	case status != 0:
+	case body != nil:
+		status = http.StatusOK
+	case result.Resource != nil:
+		status = http.StatusCreated
+	default:
+		status = http.StatusNoContent
+	}
+
+	send := body != nil && status != http.StatusNoContent && status != http.StatusNotModified
+	if send {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(status)
+	if send {
+		w.Write(body)
+	}
+}
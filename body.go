@@ -0,0 +1,57 @@
+package urest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DecodeBody decodes a JSON (the default) or XML request body into v.
+func DecodeBody(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return WrapHTTPError(http.StatusBadRequest, "invalid_body", err)
+	}
+	return decodeBody(r.Header.Get("Content-Type"), body, v)
+}
+
+func decodeBody(contentType string, body []byte, v interface{}) error {
+	mt, err := bodyMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case isJSONMediaType(mt):
+		err = json.Unmarshal(body, v)
+	case mt == "application/xml" || mt == "text/xml" || strings.HasSuffix(mt, "+xml"):
+		err = xml.NewDecoder(bytes.NewReader(body)).Decode(v)
+	default:
+		return NewHTTPError(http.StatusUnsupportedMediaType, "unsupported_media_type", "Unsupported request Content-Type '"+mt+"'")
+	}
+
+	if err != nil {
+		return &HTTPError{Status: http.StatusBadRequest, Code: "invalid_body", Message: "Invalid request body", Err: err}
+	}
+	return nil
+}
+
+func bodyMediaType(contentType string) (string, error) {
+	if contentType == "" {
+		return "application/json", nil
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", WrapHTTPError(http.StatusUnsupportedMediaType, "unsupported_media_type", err)
+	}
+	return mt, nil
+}
+
+func isJSONMediaType(mt string) bool {
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
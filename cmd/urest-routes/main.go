@@ -0,0 +1,39 @@
+// Command urest-routes renders a route dump produced by a binary's routes
+// flag (see urest.RoutesFlag) with -routes=json as text or Graphviz:
+//
+//	myserver -routes=json | urest-routes -format dot | dot -Tsvg > routes.svg
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/sporttech/urest"
+)
+
+func main() {
+	format := flag.String("format", "text", "output format: text, json or dot")
+	flag.Parse()
+
+	in := io.Reader(os.Stdin)
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("Failed to open route dump: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	routes := make([]urest.Route, 0)
+	if err := json.NewDecoder(in).Decode(&routes); err != nil {
+		log.Fatalf("Failed to read route dump: %v", err)
+	}
+
+	if err := urest.WriteRoutes(os.Stdout, routes, *format); err != nil {
+		log.Fatal(err)
+	}
+}
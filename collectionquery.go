@@ -0,0 +1,391 @@
+package urest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FILTER_EQ    FilterOp = "=="
+	FILTER_NE    FilterOp = "!="
+	FILTER_GE    FilterOp = ">="
+	FILTER_LE    FilterOp = "<="
+	FILTER_GT    FilterOp = ">"
+	FILTER_LT    FilterOp = "<"
+	FILTER_MATCH FilterOp = "=~"
+)
+
+type (
+	FilterOp string
+
+	// Filter compares the (dotted) Field of an item with Value; FILTER_MATCH
+	// takes a regular expression.
+	Filter struct {
+		Field string
+		Op    FilterOp
+		Value string
+
+		re *regexp.Regexp
+	}
+
+	SortKey struct {
+		Field string
+		Desc  bool
+	}
+
+	// CollectionQuery is parsed from ?filter=a==1,b=~^x, ?sort=-created,name
+	// and ?fields=a,b.c. Filters are combined with AND; values cannot contain
+	// commas.
+	CollectionQuery struct {
+		Filters []Filter
+		Sort    []SortKey
+		Fields  []string
+	}
+
+	fieldTree map[string]fieldTree
+)
+
+var (
+	// two-character operators first, so that ">=" is not read as ">"
+	filterOps = []FilterOp{FILTER_EQ, FILTER_NE, FILTER_GE, FILTER_LE, FILTER_MATCH, FILTER_GT, FILTER_LT}
+
+	fieldPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+(\.[A-Za-z0-9_\-]+)*$`)
+)
+
+func ParseCollectionQuery(r *http.Request) (CollectionQuery, error) {
+	q := r.URL.Query()
+	cq := CollectionQuery{}
+
+	for _, f := range splitList(q["filter"]) {
+		filter, err := parseFilter(f)
+		if err != nil {
+			return cq, err
+		}
+		cq.Filters = append(cq.Filters, filter)
+	}
+
+	for _, s := range splitList(q["sort"]) {
+		key := SortKey{Field: strings.TrimPrefix(s, "+")}
+		if strings.HasPrefix(s, "-") {
+			key = SortKey{Field: s[1:], Desc: true}
+		}
+		if !fieldPattern.MatchString(key.Field) {
+			return cq, invalidQuery("Invalid sort field '%v'", s)
+		}
+		cq.Sort = append(cq.Sort, key)
+	}
+
+	for _, f := range splitList(q["fields"]) {
+		if !fieldPattern.MatchString(f) {
+			return cq, invalidQuery("Invalid field '%v'", f)
+		}
+		cq.Fields = append(cq.Fields, f)
+	}
+
+	return cq, nil
+}
+
+func parseFilter(s string) (Filter, error) {
+	pos, op := -1, FilterOp("")
+	for _, o := range filterOps {
+		if i := strings.Index(s, string(o)); i != -1 && (pos == -1 || i < pos) {
+			pos, op = i, o
+		}
+	}
+	if pos == -1 {
+		return Filter{}, invalidQuery("Filter '%v' has no operator", s)
+	}
+
+	f := Filter{Field: s[:pos], Op: op, Value: s[pos+len(op):]}
+	if !fieldPattern.MatchString(f.Field) {
+		return f, invalidQuery("Invalid filter field '%v'", f.Field)
+	}
+	if op == FILTER_MATCH {
+		re, err := regexp.Compile(f.Value)
+		if err != nil {
+			return f, &HTTPError{Status: http.StatusBadRequest, Code: "invalid_query", Message: "Invalid filter pattern", Err: err}
+		}
+		f.re = re
+	}
+	return f, nil
+}
+
+func splitList(values []string) []string {
+	r := make([]string, 0)
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				r = append(r, s)
+			}
+		}
+	}
+	return r
+}
+
+func invalidQuery(format string, args ...interface{}) error {
+	return NewHTTPError(http.StatusBadRequest, "invalid_query", fmt.Sprintf(format, args...))
+}
+
+func (cq CollectionQuery) IsEmpty() bool {
+	return len(cq.Filters) == 0 && len(cq.Sort) == 0 && len(cq.Fields) == 0
+}
+
+// Apply filters and sorts a slice in memory, working on its JSON form.
+// Field selection is left to Select.
+func (cq CollectionQuery) Apply(items interface{}) ([]interface{}, error) {
+	g, err := toGeneric(items)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := g.([]interface{})
+	if !ok {
+		if g == nil {
+			return []interface{}{}, nil
+		}
+		return nil, NewHTTPError(http.StatusBadRequest, "invalid_query", "Only lists can be filtered and sorted")
+	}
+
+	r := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		if cq.matches(item) {
+			r = append(r, item)
+		}
+	}
+
+	if len(cq.Sort) > 0 {
+		sort.SliceStable(r, func(i, j int) bool {
+			for _, k := range cq.Sort {
+				c := compareValues(lookupField(r[i], k.Field), lookupField(r[j], k.Field))
+				if c != 0 {
+					return c < 0 != k.Desc
+				}
+			}
+			return false
+		})
+	}
+	return r, nil
+}
+
+func (cq CollectionQuery) matches(item interface{}) bool {
+	for _, f := range cq.Filters {
+		if !f.Matches(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches evaluates the filter against a generic (decoded JSON) item.
+func (f Filter) Matches(item interface{}) bool {
+	v := lookupField(item, f.Field)
+
+	if f.Op == FILTER_MATCH {
+		re := f.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(f.Value); err != nil {
+				return false
+			}
+		}
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		return v != nil && re.MatchString(s)
+	}
+
+	c, ok := compareFilterValue(v, f.Value)
+	switch f.Op {
+	case FILTER_EQ:
+		return ok && c == 0
+	case FILTER_NE:
+		return !ok || c != 0
+	case FILTER_GE:
+		return ok && c >= 0
+	case FILTER_LE:
+		return ok && c <= 0
+	case FILTER_GT:
+		return ok && c > 0
+	case FILTER_LT:
+		return ok && c < 0
+	}
+	return false
+}
+
+// compareFilterValue compares a field value with the text of a filter value,
+// interpreting the text according to the type of the field.
+func compareFilterValue(v interface{}, s string) (int, bool) {
+	switch v := v.(type) {
+	case nil:
+		if s == "null" {
+			return 0, true
+		}
+		return 0, false
+	case json.Number:
+		a, err1 := v.Float64()
+		b, err2 := strconv.ParseFloat(s, 64)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		return compareValues(a, b), true
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return 0, false
+		}
+		return compareValues(v, b), true
+	case string:
+		return strings.Compare(v, s), true
+	}
+	return 0, false
+}
+
+// compareValues orders null < booleans < numbers < strings < anything else.
+func compareValues(a interface{}, b interface{}) int {
+	if ra, rb := valueRank(a), valueRank(b); ra != rb {
+		return ra - rb
+	}
+
+	switch a := a.(type) {
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case json.Number, float64:
+		x, y := numberValue(a), numberValue(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	return 0
+}
+
+func valueRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case json.Number, float64:
+		return 2
+	case string:
+		return 3
+	}
+	return 4
+}
+
+func numberValue(v interface{}) float64 {
+	if n, ok := v.(json.Number); ok {
+		f, _ := n.Float64()
+		return f
+	}
+	return v.(float64)
+}
+
+func lookupField(item interface{}, field string) interface{} {
+	for _, name := range strings.Split(field, ".") {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		item = m[name]
+	}
+	return item
+}
+
+// Select keeps only the requested fields of data, or of each item if data
+// is a list. Nested fields are given as a.b.
+func (cq CollectionQuery) Select(data interface{}) (interface{}, error) {
+	if len(cq.Fields) == 0 {
+		return data, nil
+	}
+
+	tree := fieldTree{}
+	for _, f := range cq.Fields {
+		tree.add(strings.Split(f, "."))
+	}
+
+	g, err := toGeneric(data)
+	if err != nil {
+		return nil, err
+	}
+	return tree.selectFrom(g), nil
+}
+
+// add selects the field at path; a nil subtree selects the whole field.
+func (t fieldTree) add(path []string) {
+	sub, ok := t[path[0]]
+	switch {
+	case ok && sub == nil:
+	case len(path) == 1:
+		t[path[0]] = nil
+	default:
+		if !ok {
+			sub = fieldTree{}
+			t[path[0]] = sub
+		}
+		sub.add(path[1:])
+	}
+}
+
+func (t fieldTree) selectFrom(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		r := make([]interface{}, len(v))
+		for i, item := range v {
+			r[i] = t.selectFrom(item)
+		}
+		return r
+	case map[string]interface{}:
+		r := map[string]interface{}{}
+		for k, sub := range t {
+			fv, ok := v[k]
+			switch {
+			case !ok:
+			case sub == nil:
+				r[k] = fv
+			default:
+				r[k] = sub.selectFrom(fv)
+			}
+		}
+		return r
+	}
+	return v
+}
+
+// applyQuery runs the in-memory evaluator on the data of a DataResource that
+// opted in with QueryData: lists are filtered and sorted, and field selection
+// applies to any data. Other resources get ?filter=, ?sort= and ?fields= to
+// interpret themselves.
+func applyQuery(data interface{}, r *http.Request) (interface{}, error) {
+	cq, err := ParseCollectionQuery(r)
+	if err != nil || cq.IsEmpty() {
+		return data, err
+	}
+
+	if len(cq.Filters) > 0 || len(cq.Sort) > 0 {
+		if k := reflect.ValueOf(data).Kind(); k != reflect.Slice && k != reflect.Array {
+			return nil, NewHTTPError(http.StatusBadRequest, "invalid_query", "Only lists can be filtered and sorted")
+		}
+		if data, err = cq.Apply(data); err != nil {
+			return nil, err
+		}
+	}
+	return cq.Select(data)
+}
@@ -0,0 +1,119 @@
+package urest
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	LastModifiedResource interface {
+		LastModified(*http.Request) time.Time
+	}
+
+	entityTag struct {
+		weak   bool
+		opaque string
+	}
+)
+
+func lastModified(res Resource, r *http.Request) time.Time {
+	if lm, ok := res.(LastModifiedResource); ok {
+		return lm.LastModified(r).UTC().Truncate(time.Second)
+	}
+	return time.Time{}
+}
+
+// parseETag accepts quoted tags as well as the bare strings older resources
+// return from ETag().
+func parseETag(s string) entityTag {
+	s = strings.TrimSpace(s)
+	et := entityTag{}
+	if strings.HasPrefix(s, "W/") {
+		et.weak = true
+		s = s[2:]
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	et.opaque = s
+	return et
+}
+
+func parseETagList(header string) (tags []entityTag, wildcard bool) {
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "":
+		case "*":
+			wildcard = true
+		default:
+			tags = append(tags, parseETag(part))
+		}
+	}
+	return
+}
+
+func (a entityTag) strongMatch(b entityTag) bool {
+	return !a.weak && !b.weak && a.opaque == b.opaque
+}
+
+func (a entityTag) weakMatch(b entityTag) bool {
+	return a.opaque == b.opaque
+}
+
+func etagListMatches(header string, current string, strong bool) bool {
+	tags, wildcard := parseETagList(header)
+	if wildcard {
+		return true
+	}
+	if current == "" {
+		return false
+	}
+
+	cur := parseETag(current)
+	for _, t := range tags {
+		if (strong && t.strongMatch(cur)) || (!strong && t.weakMatch(cur)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates conditional headers in the order of RFC 9110,
+// section 13.2.2, and returns 304 or 412 if the request must not proceed or 0
+// otherwise.
+func checkPreconditions(res Resource, r *http.Request) int {
+	safe := r.Method == "GET" || r.Method == "HEAD"
+	et := ""
+	if r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != "" {
+		// hash ETags read the resource, so only when they are compared
+		et = etag(res, r)
+	}
+	lm := lastModified(res, r)
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagListMatches(im, et, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !lm.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && lm.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, et, false) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && safe && !lm.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !lm.After(t) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
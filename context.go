@@ -1,6 +1,8 @@
 package urest
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"sync"
 )
@@ -9,32 +11,72 @@ type (
 	WithContextHandler struct {
 		http.Handler
 	}
-)
 
-var (
-	requestData      = map[*http.Request]map[string]interface{}{}
-	requestDataMutex sync.Mutex
+	requestDataKey struct{}
+
+	requestData struct {
+		mu   sync.Mutex
+		data map[string]interface{}
+	}
 )
 
 func (h WithContextHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	defer delete(requestData, r)
+	h.Handler.ServeHTTP(w, WithRequestData(r))
+}
 
-	h.Handler.ServeHTTP(w, r)
+// WithRequestData returns r with an empty request data store attached to its
+// context. Requests derived from the result share the same store.
+func WithRequestData(r *http.Request) *http.Request {
+	if dataFromContext(r.Context()) != nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestDataKey{}, &requestData{data: map[string]interface{}{}}))
 }
 
-func SetRequestData(r *http.Request, name string, data interface{}) {
-	requestDataMutex.Lock()
-	defer requestDataMutex.Unlock()
+func dataFromContext(ctx context.Context) *requestData {
+	d, _ := ctx.Value(requestDataKey{}).(*requestData)
+	return d
+}
 
-	if _, ok := requestData[r]; !ok {
-		requestData[r] = map[string]interface{}{}
+// SetRequestData stores data for the rest of the request. r must carry a
+// request data store, attached by WithContextHandler, WithRequestData or the
+// Handler; data set on it is seen by every request derived from it. Without
+// a store the data is dropped and the mistake logged.
+func SetRequestData(r *http.Request, name string, data interface{}) {
+	d := dataFromContext(r.Context())
+	if d == nil {
+		log.Printf("No request data store for '%v', wrap the handler in WithContextHandler", name)
+		return
 	}
-	requestData[r][name] = data
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.data[name] = data
 }
 
 func GetRequestData(r *http.Request, name string) interface{} {
-	requestDataMutex.Lock()
-	defer requestDataMutex.Unlock()
+	return ContextData(r.Context(), name)
+}
+
+func ContextData(ctx context.Context, name string) interface{} {
+	d := dataFromContext(ctx)
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.data[name]
+}
+
+func RequestData[T any](r *http.Request, name string) (T, bool) {
+	v, ok := GetRequestData(r, name).(T)
+	return v, ok
+}
 
-	return requestData[r][name]
+func ContextValue[T any](ctx context.Context, name string) (T, bool) {
+	v, ok := ContextData(ctx, name).(T)
+	return v, ok
 }
@@ -3,8 +3,10 @@ package urest
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -22,21 +24,57 @@ type (
 		ReadRaw(string, *http.Request) ([]byte, error)
 	}
 
+	WriteResource interface {
+		NewValue() interface{}
+		Replace(interface{}, *http.Request) error
+	}
+
+	UpdateResource interface {
+		Update(interface{}, *http.Request) error
+	}
+
+	CollectionResource interface {
+		NewValue() interface{}
+		Create(interface{}, *http.Request) (Resource, error)
+	}
+
+	DeleteResource interface {
+		Delete(string, *http.Request) error
+	}
+
 	CacheDelegate interface {
 		GetCache(string, *http.Request) []byte
 		SetCache(string, *http.Request, []byte)
 	}
 
+	// ETagCacheDelegate keeps content hash ETags next to cached entries.
+	ETagCacheDelegate interface {
+		CacheDelegate
+
+		GetCacheETag(string, *http.Request) ([]byte, string)
+		SetCacheETag(string, *http.Request, []byte, string)
+	}
+
 	DefaultResourceImpl struct {
-		readRawFunc     func(string, *http.Request) ([]byte, error)
+		readRawFunc     func(string, *http.Request, Encoder) ([]byte, error)
+		data            DataResource
+		list            Pager
+		write           WriteResource
+		collection      CollectionResource
 		Parent_         Resource
 		PathSegment_    string
 		IsCollection_   bool
 		Children        map[string]Resource
 		AllowedMethods_ []string
 		Actions         map[string]func(*http.Request) error
+		ResultActions   map[string]func(*http.Request) (*ActionResult, error)
+		Queries         map[string]Resource
+		AsyncActions    map[string]func(*http.Request) (JobFunc, error)
 		ContentType_    string
 		Gzip            bool
+		HashETag        bool
+		QueryData       bool
+		Schema          *JSONSchema
 		CacheDuration   time.Duration
 		cache           CacheDelegate
 	}
@@ -50,38 +88,44 @@ func NewDefaultResourceImpl(parent Resource, pathSegment string) *DefaultResourc
 		Children:        map[string]Resource{},
 		AllowedMethods_: []string{"HEAD"},
 		Actions:         map[string]func(*http.Request) error{},
+		ResultActions:   map[string]func(*http.Request) (*ActionResult, error){},
+		Queries:         map[string]Resource{},
+		AsyncActions:    map[string]func(*http.Request) (JobFunc, error){},
 		ContentType_:    CONTENT_TYPE_JSON,
 		Gzip:            true,
 	}
 }
 
 func (d *DefaultResourceImpl) SetDataDelegate(del DataResource) {
-	if ct := d.ContentType(); ct != CONTENT_TYPE_JSON {
-		panic("Resource has Data function but non-JSON Content-Type")
+	if EncoderFor(d.ContentType()) == nil {
+		panic("Resource has Data function but no encoder for its Content-Type")
 	}
 
-	d.readRawFunc = func(prefix string, r *http.Request) ([]byte, error) {
-		isLive := GetRequestData(r,"livedata")
-		data := interface{}(nil)
-		err := error(nil)
-		if b, ok := isLive.(bool); ok && b {
-			data, err = del.LiveData(prefix, r)
-		} else {
-			data, err = del.Data(prefix, r)
-		}
+	d.addMethods("GET")
+	if d.write != nil {
+		d.addMethods("PATCH")
+	}
+	d.data = del
+	d.readRawFunc = func(prefix string, r *http.Request, enc Encoder) ([]byte, error) {
+		data, err := d.readData(prefix, r)
 		if err != nil {
 			return nil, err
 		}
 		if data == nil {
 			return []byte{}, nil
 		}
+		if d.QueryData {
+			if data, err = applyQuery(data, r); err != nil {
+				return nil, err
+			}
+		}
 
-		encoded, err := json.Marshal(data)
+		encoded, err := enc.Marshal(data)
 		if err != nil {
 			return encoded, err
 		}
 
-		mayEncode := strings.Contains(r.RequestURI,"accept-b64-gzip=true")
+		mayEncode := enc.Format() == "json" && strings.Contains(r.RequestURI,"accept-b64-gzip=true")
 		if len(encoded) > 20 * 1024 && mayEncode {
 			var b bytes.Buffer
 			gz := gzip.NewWriter(&b)
@@ -104,20 +148,71 @@ func (d *DefaultResourceImpl) SetDataDelegate(del DataResource) {
 	}
 }
 
+// SetCacheDelegate caches encoded representations. With HashETag, del
+// should be an ETagCacheDelegate: a plain CacheDelegate does not keep ETags,
+// so cache hits are sent without one and never answered with 304.
 func (d *DefaultResourceImpl) SetCacheDelegate(del CacheDelegate) {
 	d.cache = del
 }
 
 func (d *DefaultResourceImpl) SetRawReadDelegate(del RawReadResource) {
-	d.readRawFunc = func(prefix string, r *http.Request) ([]byte, error) {
+	d.addMethods("GET")
+	d.data = nil
+	d.readRawFunc = func(prefix string, r *http.Request, _ Encoder) ([]byte, error) {
 		return del.ReadRaw(prefix, r)
 	}
 }
 
+// SetWriteDelegate enables PUT. PATCH is enabled too if del is an
+// UpdateResource or if the resource has a data delegate, in which case merge
+// and JSON patches are applied to Data and the result is passed to Replace.
+func (d *DefaultResourceImpl) SetWriteDelegate(del WriteResource) {
+	if err := checkRules(del.NewValue()); err != nil {
+		panic(fmt.Sprintf("Write delegate has invalid validation rules: %v", err))
+	}
+	d.setWriteDelegate(del)
+}
+
+// setWriteDelegate is SetWriteDelegate for callers that checked the rules of
+// del beforehand.
+func (d *DefaultResourceImpl) setWriteDelegate(del WriteResource) {
+	d.write = del
+	d.addMethods("PUT")
+	if _, ok := del.(UpdateResource); ok || d.data != nil {
+		d.addMethods("PATCH")
+	}
+}
+
+// SetCollectionDelegate makes the resource a collection accepting POST. If
+// del is a DeleteResource, DefaultResourceImpl children allow DELETE.
+func (d *DefaultResourceImpl) SetCollectionDelegate(del CollectionResource) {
+	if err := checkRules(del.NewValue()); err != nil {
+		panic(fmt.Sprintf("Collection delegate has invalid validation rules: %v", err))
+	}
+
+	d.collection = del
+	d.IsCollection_ = true
+	d.addMethods("POST")
+}
+
+func (d *DefaultResourceImpl) addMethods(methods ...string) {
+	for _, m := range methods {
+		if index(d.AllowedMethods_, m) == -1 {
+			d.AllowedMethods_ = append(d.AllowedMethods_, m)
+		}
+	}
+}
+
 func (d *DefaultResourceImpl) AddAction(action string, f func(*http.Request) error) {
 	d.Actions[action] = f
 }
 
+// AddResultAction adds an action whose result is sent to the client, see
+// ActionResult and TypedAction.
+func (d *DefaultResourceImpl) AddResultAction(action string, f func(*http.Request) (*ActionResult, error)) {
+	d.ResultActions[action] = f
+}
+
 func (d *DefaultResourceImpl) Parent() Resource {
 	return d.Parent_
 }
@@ -131,19 +226,55 @@ func (d *DefaultResourceImpl) Child(name string, r *http.Request) Resource {
 }
 
 func (d *DefaultResourceImpl) AllowedMethods() []string {
+	if p, ok := d.Parent_.(*DefaultResourceImpl); ok && index(d.AllowedMethods_, "DELETE") == -1 {
+		if _, ok := p.collection.(DeleteResource); ok {
+			return append(append([]string{}, d.AllowedMethods_...), "DELETE")
+		}
+	}
 	return d.AllowedMethods_
 }
 
 func (d *DefaultResourceImpl) AllowedActions() []string {
-	r := make([]string, 0, len(d.Actions))
+	r := make([]string, 0, len(d.Actions)+len(d.ResultActions))
 	for a, _ := range d.Actions {
 		r = append(r, a)
 	}
+	for a, _ := range d.ResultActions {
+		if _, ok := d.Actions[a]; !ok {
+			r = append(r, a)
+		}
+	}
+	for a, _ := range d.AsyncActions {
+		if _, ok := d.Actions[a]; !ok && d.ResultActions[a] == nil {
+			r = append(r, a)
+		}
+	}
 	return r
 }
 
+// ETag is the content hash of the representation when HashETag is set, so
+// that If-Match and If-None-Match work for writes. GET and HEAD leave it to
+// Read, which encodes the representation anyway.
 func (d *DefaultResourceImpl) ETag(r *http.Request) string {
-	return ""
+	if !d.HashETag || d.readRawFunc == nil || d.list != nil || r.Method == "GET" || r.Method == "HEAD" {
+		return ""
+	}
+
+	enc := Encoder(nil)
+	if d.data != nil {
+		def := EncoderFor(d.ContentType())
+		if e, err := NegotiateEncoder(r, def); err == nil {
+			enc = e
+		} else {
+			enc = def
+		}
+	}
+
+	data, err := d.readRawFunc(requestPrefix(r), r, enc)
+	if err != nil {
+		return ""
+	}
+	return contentETag(data)
 }
 
 func (d *DefaultResourceImpl) Expires() time.Time {
@@ -165,17 +296,44 @@ func (d *DefaultResourceImpl) ContentType() string {
 }
 
 func (d *DefaultResourceImpl) Read(urlPrefix string, w http.ResponseWriter, r *http.Request) error {
-	if d.readRawFunc == nil {
+	if d.readRawFunc == nil && d.list == nil {
 		panic("Not implemented")
 	}
 
-	if d.cache != nil {
-		if val := d.cache.GetCache(urlPrefix, r); val != nil {
-			w.Header().Set("Vary", "Accept-Encoding")
+	enc := Encoder(nil)
+	useCache := d.cache != nil && d.list == nil
+	w.Header().Set("Vary", "Accept-Encoding")
+	if d.data != nil || d.list != nil {
+		def := EncoderFor(d.ContentType())
+		e, err := NegotiateEncoder(r, def)
+		if err != nil {
+			return err
+		}
+
+		// only the default representation is cached
+		enc = e
+		useCache = useCache && def != nil && enc.Format() == def.Format()
+		if d.QueryData {
+			if cq, err := ParseCollectionQuery(r); err != nil || !cq.IsEmpty() {
+				useCache = false
+			}
+		}
+		w.Header().Set("Content-Type", enc.ContentType())
+		w.Header().Set("Vary", "Accept, Accept-Encoding")
+	}
+
+	if useCache {
+		val, et := d.getCache(urlPrefix, r)
+		if val != nil {
+			if d.HashETag && et != "" && notModified(w, r, et) {
+				return nil
+			}
+
 			if d.Gzip && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
 				w.Header().Set("Content-Encoding", "gzip")
 				w.Header().Set("Content-Length", strconv.Itoa(len(val)))
 			} else {
+				w.Header().Set("Accept-Ranges", "bytes")
 				w.Header().Set("Content-Length", strconv.Itoa(len(val)))
 			}
 			w.Write(val)
@@ -183,12 +341,24 @@ func (d *DefaultResourceImpl) Read(urlPrefix string, w http.ResponseWriter, r *h
 		}
 	}
 
-	data, err := d.readRawFunc(urlPrefix, r)
+	data, err := []byte(nil), error(nil)
+	if d.list != nil {
+		data, err = listPage(d.list, RelativeURL(urlPrefix, d), w, r, enc)
+	} else {
+		data, err = d.readRawFunc(urlPrefix, r, enc)
+	}
 	if err != nil {
 		return err
 	}
 
-	w.Header().Set("Vary", "Accept-Encoding")
+	et := ""
+	if d.HashETag {
+		et = contentETag(data)
+		if notModified(w, r, et) {
+			return nil
+		}
+	}
+
 	if d.Gzip && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
 		b := bytes.Buffer{}
 		gz := gzip.NewWriter(&b)
@@ -198,45 +368,219 @@ func (d *DefaultResourceImpl) Read(urlPrefix string, w http.ResponseWriter, r *h
 		w.Header().Set("Content-Encoding", "gzip")
 		w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
 		w.Write(b.Bytes())
-		if d.cache != nil {
-			d.cache.SetCache(urlPrefix, r, b.Bytes())
+		if useCache {
+			d.setCache(urlPrefix, r, b.Bytes(), et)
 		}
 	} else {
+		w.Header().Set("Accept-Ranges", "bytes")
 		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 		w.Write(data)
-		if d.cache != nil {
-			d.cache.SetCache(urlPrefix, r, data)
+		if useCache {
+			d.setCache(urlPrefix, r, data, et)
 		}
 	}
 
 	return nil
 }
 
-func (*DefaultResourceImpl) Update(*http.Request) error {
-	panic("Not implemented")
+func (d *DefaultResourceImpl) getCache(urlPrefix string, r *http.Request) ([]byte, string) {
+	if ec, ok := d.cache.(ETagCacheDelegate); ok {
+		return ec.GetCacheETag(urlPrefix, r)
+	}
+	return d.cache.GetCache(urlPrefix, r), ""
 }
 
-func (*DefaultResourceImpl) Replace(*http.Request) error {
-	panic("Not implemented")
+func (d *DefaultResourceImpl) setCache(urlPrefix string, r *http.Request, data []byte, et string) {
+	if ec, ok := d.cache.(ETagCacheDelegate); ok {
+		ec.SetCacheETag(urlPrefix, r, data, et)
+	} else {
+		d.cache.SetCache(urlPrefix, r, data)
+	}
+}
+
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified sets the ETag header and answers 304 if the client already has
+// the representation.
+func notModified(w http.ResponseWriter, r *http.Request, et string) bool {
+	w.Header().Set("ETag", et)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagListMatches(inm, et, false) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func (d *DefaultResourceImpl) Update(r *http.Request) error {
+	u, ok := d.write.(UpdateResource)
+	if !ok && (d.write == nil || d.data == nil) {
+		panic("Not implemented")
+	}
+
+	mt := mediaType(r.Header.Get("Content-Type"))
+	switch {
+	case (mt == CONTENT_TYPE_MERGE_PATCH || mt == CONTENT_TYPE_JSON_PATCH) && d.data != nil:
+		return d.patch(mt, r)
+	case ok && mt != CONTENT_TYPE_MERGE_PATCH && mt != CONTENT_TYPE_JSON_PATCH:
+		v := d.write.NewValue()
+		if err := d.decode(r, v, true); err != nil {
+			return err
+		}
+		return u.Update(v, r)
+	}
+
+	return unsupportedPatch(mt, d.AcceptPatch())
+}
+
+// readData calls LiveData instead of Data for requests marked "livedata".
+func (d *DefaultResourceImpl) readData(prefix string, r *http.Request) (interface{}, error) {
+	if live, ok := GetRequestData(r, "livedata").(bool); ok && live {
+		return d.data.LiveData(prefix, r)
+	}
+	return d.data.Data(prefix, r)
+}
+
+// patch applies the patch to the representation the client read, with the
+// same URL prefix and live data setting.
+func (d *DefaultResourceImpl) patch(mt string, r *http.Request) error {
+	cur, err := d.readData(requestPrefix(r), r)
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+
+	p, err := io.ReadAll(r.Body)
+	if err != nil {
+		return WrapHTTPError(http.StatusBadRequest, "invalid_body", err)
+	}
+	patched, err := ApplyPatch(mt, doc, p)
+	if err != nil {
+		return err
+	}
+
+	if d.Schema != nil {
+		doc, _ := decodeGeneric(patched)
+		if err := d.Schema.ValidateJSON(doc); err != nil {
+			return err
+		}
+	}
+
+	v := d.write.NewValue()
+	if err := json.Unmarshal(patched, v); err != nil {
+		return &HTTPError{Status: http.StatusUnprocessableEntity, Code: "invalid_patch_result", Message: "Patched document is invalid", Err: err}
+	}
+	if err := Validate(v); err != nil {
+		return err
+	}
+	return d.write.Replace(v, r)
+}
+
+// decode reads the request body into v, checking it against Schema before
+// and against the struct tag rules of v after decoding. Partial values (PATCH
+// bodies) skip required checks.
+func (d *DefaultResourceImpl) decode(r *http.Request, v interface{}, partial bool) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return WrapHTTPError(http.StatusBadRequest, "invalid_body", err)
+	}
+
+	ct := r.Header.Get("Content-Type")
+	if mt, err := bodyMediaType(ct); err == nil && isJSONMediaType(mt) && d.Schema != nil {
+		doc, err := decodeGeneric(body)
+		if err != nil {
+			return &HTTPError{Status: http.StatusBadRequest, Code: "invalid_body", Message: "Invalid request body", Err: err}
+		}
+		if err := d.Schema.validateJSON(doc, partial); err != nil {
+			return err
+		}
+	}
+
+	if err := decodeBody(ct, body, v); err != nil {
+		return err
+	}
+	return validate(v, partial)
+}
+
+func (d *DefaultResourceImpl) AcceptPatch() []string {
+	types := make([]string, 0)
+	if d.data != nil && d.write != nil {
+		types = append(types, CONTENT_TYPE_MERGE_PATCH, CONTENT_TYPE_JSON_PATCH)
+	}
+	if _, ok := d.write.(UpdateResource); ok {
+		types = append(types, "application/json")
+	}
+	if len(types) == 0 {
+		types = append(types, mediaType(d.ContentType()))
+	}
+	return types
+}
+
+func (d *DefaultResourceImpl) Replace(r *http.Request) error {
+	if d.write == nil {
+		panic("Not implemented")
+	}
+
+	v := d.write.NewValue()
+	if err := d.decode(r, v, false); err != nil {
+		return err
+	}
+	return d.write.Replace(v, r)
 }
 
 func (d *DefaultResourceImpl) Do(action string, r *http.Request) error {
 	if a := d.Actions[action]; a != nil {
 		return a(r)
 	}
+	if a := d.ResultActions[action]; a != nil {
+		_, err := a(r)
+		return err
+	}
+	if a := d.AsyncActions[action]; a != nil {
+		f, err := a(r)
+		if err != nil {
+			return err
+		}
+		_, err = f(r.Context(), func(float64) {})
+		return err
+	}
 
 	panic("Not implemented")
 }
 
+func (d *DefaultResourceImpl) DoAction(action string, r *http.Request) (*ActionResult, error) {
+	if a := d.ResultActions[action]; a != nil {
+		return a(r)
+	}
+	return nil, d.Do(action, r)
+}
+
 func (d *DefaultResourceImpl) IsCollection() bool {
 	return d.IsCollection_
 }
 
-func (*DefaultResourceImpl) Create(*http.Request) (Resource, error) {
-	panic("Not implemented")
+func (d *DefaultResourceImpl) Create(r *http.Request) (Resource, error) {
+	if d.collection == nil {
+		panic("Not implemented")
+	}
+
+	v := d.collection.NewValue()
+	if err := d.decode(r, v, false); err != nil {
+		return nil, err
+	}
+	return d.collection.Create(v, r)
 }
 
-func (*DefaultResourceImpl) Delete(string, *http.Request) error {
+func (d *DefaultResourceImpl) Delete(name string, r *http.Request) error {
+	if del, ok := d.collection.(DeleteResource); ok {
+		return del.Delete(name, r)
+	}
+
 	panic("Not implemented")
 }
 
@@ -0,0 +1,135 @@
+package urest
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type (
+	// Describable resources tell tree walkers (OpenAPI generation, route
+	// dumps, validation) what Child() cannot: names of static children and,
+	// for dynamic children, the path parameter and a representative child.
+	Describable interface {
+		Describe() Description
+	}
+
+	Description struct {
+		Summary      string
+		Children     []string
+		ChildParam   string
+		ChildExample Resource
+		DataType     reflect.Type
+		BodyType     reflect.Type
+		CreateType   reflect.Type
+	}
+
+	TypedDataResource interface {
+		DataType() reflect.Type
+	}
+
+	treeNode struct {
+		res    Resource
+		path   string
+		parent string
+		params []string
+		query  bool
+	}
+)
+
+func (d *DefaultResourceImpl) Describe() Description {
+	desc := Description{Children: make([]string, 0, len(d.Children))}
+	for name := range d.Children {
+		desc.Children = append(desc.Children, name)
+	}
+	sort.Strings(desc.Children)
+
+	if td, ok := d.data.(TypedDataResource); ok {
+		desc.DataType = td.DataType()
+	}
+	if d.write != nil {
+		desc.BodyType = reflect.TypeOf(d.write.NewValue()).Elem()
+	}
+	if d.collection != nil {
+		desc.CreateType = reflect.TypeOf(d.collection.NewValue()).Elem()
+	}
+	return desc
+}
+
+func (c *TypedCollection[ID, T]) Describe() Description {
+	desc := c.DefaultResourceImpl.Describe()
+	desc.ChildParam = "id"
+	desc.ChildExample = c.Item(*new(ID))
+	return desc
+}
+
+func (d *DefaultResourceImpl) defaultImpl() *DefaultResourceImpl {
+	return d
+}
+
+// defaultImpl finds the DefaultResourceImpl behind res, which may embed it.
+func defaultImpl(res Resource) *DefaultResourceImpl {
+	if di, ok := res.(interface{ defaultImpl() *DefaultResourceImpl }); ok {
+		return di.defaultImpl()
+	}
+	return nil
+}
+
+func describe(res Resource) Description {
+	if d, ok := res.(Describable); ok {
+		return d.Describe()
+	}
+	return Description{}
+}
+
+// walkTree visits the resources reachable from root in depth-first order with
+// their URL paths, dynamic children appearing as {param} segments.
+func walkTree(root Resource, prefix string, visit func(treeNode)) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	seen := map[Resource]bool{}
+
+	var walk func(n treeNode)
+	walk = func(n treeNode) {
+		if reflect.TypeOf(n.res).Comparable() {
+			if seen[n.res] {
+				return
+			}
+			seen[n.res] = true
+		}
+		visit(n)
+
+		base := n.path
+		if !strings.HasSuffix(base, "/") {
+			base += "/"
+		}
+
+		desc := describe(n.res)
+		for _, name := range desc.Children {
+			if ch := n.res.Child(name, r); ch != nil {
+				walk(treeNode{ch, childPath(base, name, ch), n.path, n.params, false})
+			}
+		}
+		if qr, ok := n.res.(QueryResource); ok {
+			for _, name := range qr.AllowedQueries() {
+				if q := qr.Query(name, r); q != nil {
+					walk(treeNode{q, childPath(base, name, q), n.path, n.params, true})
+				}
+			}
+		}
+		if desc.ChildParam != "" && desc.ChildExample != nil {
+			params := append(append([]string{}, n.params...), desc.ChildParam)
+			walk(treeNode{desc.ChildExample, childPath(base, "{"+desc.ChildParam+"}", desc.ChildExample), n.path, params, false})
+		}
+	}
+
+	walk(treeNode{root, RelativeURL(prefix, root).Path, "", []string{}, false})
+}
+
+func childPath(base string, segment string, ch Resource) string {
+	p := base + segment
+	if ch.IsCollection() {
+		p += "/"
+	}
+	return p
+}
@@ -0,0 +1,268 @@
+package urest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	CONTENT_TYPE_XML     = "application/xml; charset=utf-8"
+	CONTENT_TYPE_CSV     = "text/csv; charset=utf-8"
+	CONTENT_TYPE_MSGPACK = "application/msgpack"
+	CONTENT_TYPE_CBOR    = "application/cbor"
+)
+
+type (
+	// Encoder turns DataResource output into a representation. Format is the
+	// name clients may pass as ?format= to bypass Accept negotiation.
+	Encoder interface {
+		Format() string
+		ContentType() string
+		Marshal(interface{}) ([]byte, error)
+	}
+
+	jsonEncoder    struct{}
+	xmlEncoder     struct{}
+	csvEncoder     struct{}
+	msgpackEncoder struct{}
+	cborEncoder    struct{}
+)
+
+var (
+	encoders      = []Encoder{jsonEncoder{}, xmlEncoder{}, csvEncoder{}, msgpackEncoder{}, cborEncoder{}}
+	encodersMutex sync.RWMutex
+)
+
+// RegisterEncoder adds enc to the encoders offered to clients, replacing the
+// encoder of the same format if there is one.
+func RegisterEncoder(enc Encoder) {
+	encodersMutex.Lock()
+	defer encodersMutex.Unlock()
+
+	for i, e := range encoders {
+		if e.Format() == enc.Format() {
+			encoders[i] = enc
+			return
+		}
+	}
+	encoders = append(encoders, enc)
+}
+
+func Encoders() []Encoder {
+	encodersMutex.RLock()
+	defer encodersMutex.RUnlock()
+
+	return append([]Encoder{}, encoders...)
+}
+
+func EncoderFor(contentType string) Encoder {
+	mt := mediaType(contentType)
+	for _, e := range Encoders() {
+		if mediaType(e.ContentType()) == mt {
+			return e
+		}
+	}
+	return nil
+}
+
+// NegotiateEncoder picks the encoder for r from the ?format= parameter or the
+// Accept header, preferring def when the client does not care.
+func NegotiateEncoder(r *http.Request, def Encoder) (Encoder, error) {
+	all := Encoders()
+
+	if f := r.URL.Query().Get("format"); f != "" {
+		for _, e := range all {
+			if e.Format() == f {
+				return e, nil
+			}
+		}
+		return nil, NewHTTPError(http.StatusNotAcceptable, "not_acceptable", fmt.Sprintf("Unknown format '%v'", f))
+	}
+
+	offers := make([]string, 0, len(all)+1)
+	byType := map[string]Encoder{}
+	if def != nil {
+		offers = append(offers, def.ContentType())
+		byType[def.ContentType()] = def
+	}
+	for _, e := range all {
+		if _, ok := byType[e.ContentType()]; !ok {
+			offers = append(offers, e.ContentType())
+			byType[e.ContentType()] = e
+		}
+	}
+
+	if ct := negotiate(r.Header.Get("Accept"), offers); ct != "" {
+		return byType[ct], nil
+	}
+	return nil, NewHTTPError(http.StatusNotAcceptable, "not_acceptable", "No acceptable representation")
+}
+
+// toGeneric converts v to the nil/bool/json.Number/string/slice/map tree
+// encoding/json would produce, so that every encoder honours json tags.
+func toGeneric(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGeneric(b)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (jsonEncoder) Format() string      { return "json" }
+func (jsonEncoder) ContentType() string { return CONTENT_TYPE_JSON }
+
+func (jsonEncoder) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (xmlEncoder) Format() string      { return "xml" }
+func (xmlEncoder) ContentType() string { return CONTENT_TYPE_XML }
+
+func (xmlEncoder) Marshal(v interface{}) ([]byte, error) {
+	if rv := reflect.Indirect(reflect.ValueOf(v)); rv.Kind() == reflect.Struct {
+		if b, err := xml.Marshal(v); err == nil {
+			return append([]byte(xml.Header), b...), nil
+		}
+	}
+
+	g, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	b := bytes.Buffer{}
+	b.WriteString(xml.Header)
+	writeXML(&b, "data", g)
+	return b.Bytes(), nil
+}
+
+func writeXML(b *bytes.Buffer, name string, v interface{}) {
+	attr := ""
+	if !isXMLName(name) {
+		attr = fmt.Sprintf(" key=\"%v\"", xmlEscape(name))
+		name = "entry"
+	}
+
+	if v == nil {
+		fmt.Fprintf(b, "<%v%v/>", name, attr)
+		return
+	}
+
+	fmt.Fprintf(b, "<%v%v>", name, attr)
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			writeXML(b, k, t[k])
+		}
+	case []interface{}:
+		for _, e := range t {
+			writeXML(b, "item", e)
+		}
+	default:
+		b.WriteString(xmlEscape(fmt.Sprint(t)))
+	}
+	fmt.Fprintf(b, "</%v>", name)
+}
+
+func isXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, c := range s {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || !(c == '-' || c == '.' || (c >= '0' && c <= '9'))) {
+			return false
+		}
+	}
+	return true
+}
+
+func xmlEscape(s string) string {
+	b := bytes.Buffer{}
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (csvEncoder) Format() string      { return "csv" }
+func (csvEncoder) ContentType() string { return CONTENT_TYPE_CSV }
+
+// Marshal writes one row per slice element. Columns are the union of object
+// keys; nested values are written as JSON.
+func (csvEncoder) Marshal(v interface{}) ([]byte, error) {
+	g, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, ok := g.([]interface{})
+	if !ok {
+		return nil, NewHTTPError(http.StatusNotAcceptable, "not_acceptable", "CSV is only available for lists")
+	}
+
+	seen := map[string]bool{}
+	columns := make([]string, 0)
+	for _, row := range rows {
+		if m, ok := row.(map[string]interface{}); ok {
+			for _, k := range sortedKeys(m) {
+				if !seen[k] {
+					seen[k] = true
+					columns = append(columns, k)
+				}
+			}
+		}
+	}
+	if len(columns) == 0 {
+		columns = append(columns, "value")
+	}
+
+	b := bytes.Buffer{}
+	cw := csv.NewWriter(&b)
+	cw.Write(columns)
+	for _, row := range rows {
+		rec := make([]string, len(columns))
+		if m, ok := row.(map[string]interface{}); ok {
+			for i, c := range columns {
+				rec[i] = csvCell(m[c])
+			}
+		} else {
+			rec[0] = csvCell(row)
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+
+	return b.Bytes(), cw.Error()
+}
+
+func csvCell(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return fmt.Sprint(t)
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,197 @@
+package urest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+func (msgpackEncoder) Format() string      { return "msgpack" }
+func (msgpackEncoder) ContentType() string { return CONTENT_TYPE_MSGPACK }
+
+func (msgpackEncoder) Marshal(v interface{}) ([]byte, error) {
+	g, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	b := bytes.Buffer{}
+	err = writeMsgpack(&b, g)
+	return b.Bytes(), err
+}
+
+func writeMsgpack(b *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if t {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			writeMsgpackInt(b, i)
+		} else if u, err := strconv.ParseUint(t.String(), 10, 64); err == nil {
+			b.WriteByte(0xcf)
+			binary.Write(b, binary.BigEndian, u)
+		} else if f, err := t.Float64(); err == nil {
+			b.WriteByte(0xcb)
+			binary.Write(b, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return err
+		}
+	case string:
+		n := len(t)
+		switch {
+		case n < 32:
+			b.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			b.Write([]byte{0xd9, byte(n)})
+		case n <= math.MaxUint16:
+			b.WriteByte(0xda)
+			binary.Write(b, binary.BigEndian, uint16(n))
+		default:
+			b.WriteByte(0xdb)
+			binary.Write(b, binary.BigEndian, uint32(n))
+		}
+		b.WriteString(t)
+	case []interface{}:
+		writeMsgpackLen(b, len(t), 0x90, 0xdc, 0xdd)
+		for _, e := range t {
+			if err := writeMsgpack(b, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		writeMsgpackLen(b, len(t), 0x80, 0xde, 0xdf)
+		for _, k := range sortedKeys(t) {
+			writeMsgpack(b, k)
+			if err := writeMsgpack(b, t[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Cannot encode %T as MessagePack", v)
+	}
+	return nil
+}
+
+func writeMsgpackInt(b *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		b.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		b.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		b.Write([]byte{0xd0, byte(int8(i))})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		b.WriteByte(0xd1)
+		binary.Write(b, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		b.WriteByte(0xd2)
+		binary.Write(b, binary.BigEndian, int32(i))
+	default:
+		b.WriteByte(0xd3)
+		binary.Write(b, binary.BigEndian, i)
+	}
+}
+
+func writeMsgpackLen(b *bytes.Buffer, n int, fix byte, c16 byte, c32 byte) {
+	switch {
+	case n < 16:
+		b.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(c16)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(c32)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+func (cborEncoder) Format() string      { return "cbor" }
+func (cborEncoder) ContentType() string { return CONTENT_TYPE_CBOR }
+
+func (cborEncoder) Marshal(v interface{}) ([]byte, error) {
+	g, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	b := bytes.Buffer{}
+	err = writeCBOR(&b, g)
+	return b.Bytes(), err
+}
+
+func writeCBOR(b *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		b.WriteByte(0xf6)
+	case bool:
+		if t {
+			b.WriteByte(0xf5)
+		} else {
+			b.WriteByte(0xf4)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			if i >= 0 {
+				writeCBORHead(b, 0, uint64(i))
+			} else {
+				writeCBORHead(b, 1, uint64(-(i + 1)))
+			}
+		} else if u, err := strconv.ParseUint(t.String(), 10, 64); err == nil {
+			writeCBORHead(b, 0, u)
+		} else if f, err := t.Float64(); err == nil {
+			b.WriteByte(0xfb)
+			binary.Write(b, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return err
+		}
+	case string:
+		writeCBORHead(b, 3, uint64(len(t)))
+		b.WriteString(t)
+	case []interface{}:
+		writeCBORHead(b, 4, uint64(len(t)))
+		for _, e := range t {
+			if err := writeCBOR(b, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		writeCBORHead(b, 5, uint64(len(t)))
+		for _, k := range sortedKeys(t) {
+			writeCBOR(b, k)
+			if err := writeCBOR(b, t[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Cannot encode %T as CBOR", v)
+	}
+	return nil
+}
+
+func writeCBORHead(b *bytes.Buffer, major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		b.WriteByte(m | byte(n))
+	case n <= math.MaxUint8:
+		b.Write([]byte{m | 24, byte(n)})
+	case n <= math.MaxUint16:
+		b.WriteByte(m | 25)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		b.WriteByte(m | 26)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	default:
+		b.WriteByte(m | 27)
+		binary.Write(b, binary.BigEndian, n)
+	}
+}
@@ -0,0 +1,140 @@
+package urest
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotFound = NewHTTPError(http.StatusNotFound, "not_found", "Not found")
+
+	errorCodes = []int{
+		http.StatusContinue,
+		http.StatusSwitchingProtocols,
+
+		http.StatusOK,
+		http.StatusCreated,
+		http.StatusAccepted,
+		http.StatusNonAuthoritativeInfo,
+		http.StatusNoContent,
+		http.StatusResetContent,
+		http.StatusPartialContent,
+
+		http.StatusMultipleChoices,
+		http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusSeeOther,
+		http.StatusNotModified,
+		http.StatusUseProxy,
+		http.StatusTemporaryRedirect,
+
+		http.StatusBadRequest,
+		http.StatusUnauthorized,
+		http.StatusPaymentRequired,
+		http.StatusForbidden,
+		http.StatusNotFound,
+		http.StatusMethodNotAllowed,
+		http.StatusNotAcceptable,
+		http.StatusProxyAuthRequired,
+		http.StatusRequestTimeout,
+		http.StatusConflict,
+		http.StatusGone,
+		http.StatusLengthRequired,
+		http.StatusPreconditionFailed,
+		http.StatusRequestEntityTooLarge,
+		http.StatusRequestURITooLong,
+		http.StatusUnsupportedMediaType,
+		http.StatusRequestedRangeNotSatisfiable,
+		http.StatusExpectationFailed,
+		http.StatusTeapot,
+
+		http.StatusInternalServerError,
+		http.StatusNotImplemented,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		http.StatusHTTPVersionNotSupported,
+	}
+)
+
+type (
+	HTTPError struct {
+		Status  int
+		Code    string
+		Message string
+		Details interface{}
+		Header  http.Header
+		Err     error
+	}
+)
+
+func NewHTTPError(status int, code string, message string) *HTTPError {
+	return &HTTPError{Status: status, Code: code, Message: message}
+}
+
+func WrapHTTPError(status int, code string, err error) *HTTPError {
+	return &HTTPError{Status: status, Code: code, Err: err}
+}
+
+func (e *HTTPError) WithDetails(details interface{}) *HTTPError {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+func (e *HTTPError) Error() string {
+	switch {
+	case e.Err == nil && e.Message == "":
+		return http.StatusText(e.StatusCode())
+	case e.Err == nil:
+		return e.Message
+	case e.Message == "":
+		return e.Err.Error()
+	default:
+		return e.Message + ": " + e.Err.Error()
+	}
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HTTPError) StatusCode() int {
+	if e.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return e.Status
+}
+
+// ErrorStatus finds the HTTP status for err: an *HTTPError anywhere in the
+// chain wins, then the legacy "404 ..." message prefix, then 500.
+func ErrorStatus(err error) int {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.StatusCode()
+	}
+	if code, ok := prefixStatus(err.Error()); ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+func setErrorHeaders(w http.ResponseWriter, err error) {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		for k, v := range he.Header {
+			w.Header()[k] = v
+		}
+	}
+}
+
+func prefixStatus(e string) (int, bool) {
+	for _, code := range errorCodes {
+		if strings.HasPrefix(e, strconv.Itoa(code)+" ") {
+			return code, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package urest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type escapeColl struct {
+	*DefaultResourceImpl
+}
+
+func (c escapeColl) Child(name string, r *http.Request) Resource {
+	ch := NewDefaultResourceImpl(c, name)
+	ch.SetRawReadDelegate(escapeItem(name))
+	return ch
+}
+
+type escapeItem string
+
+func (e escapeItem) ReadRaw(string, *http.Request) ([]byte, error) {
+	return []byte(e), nil
+}
+
+func newEscapeHandler() (*Handler, escapeColl) {
+	root := NewDefaultResourceImpl(nil, "")
+	root.IsCollection_ = true
+	c := escapeColl{NewDefaultResourceImpl(root, "items")}
+	c.IsCollection_ = true
+	root.Children["items"] = c
+	return NewHandler(root, "/api/"), c
+}
+
+func TestEscapedSegmentsRoundTrip(t *testing.T) {
+	h, c := newEscapeHandler()
+
+	tests := []struct {
+		name    string
+		escaped string
+	}{
+		{"a/b", "a%2Fb"},
+		{"x y", "x%20y"},
+		{"a:b", "a:b"},
+		{"€uro", "%E2%82%ACuro"},
+		{"q?x=1", "q%3Fx=1"},
+	}
+
+	for _, tt := range tests {
+		path := "/api/items/" + tt.escaped
+
+		u := RelativeURL("/api/", c.Child(tt.name, nil))
+		if u.Path != "/api/items/"+tt.name {
+			t.Errorf("%q: RelativeURL path %q", tt.name, u.Path)
+		}
+		if u.EscapedPath() != path {
+			t.Errorf("%q: RelativeURL escaped path %q, want %q", tt.name, u.EscapedPath(), path)
+		}
+
+		r := httptest.NewRequest("GET", path, nil)
+		if au := AbsoluteURL(r, "/api/", c.Child(tt.name, nil)); au.String() != "http://example.com"+path {
+			t.Errorf("%q: AbsoluteURL %q", tt.name, au.String())
+		}
+
+		res, action, err := h.Resolve(r)
+		if err != nil || action != nil {
+			t.Fatalf("%q: Resolve failed: %v, %v", tt.name, err, action)
+		}
+		if res.PathSegment() != tt.name {
+			t.Errorf("%q: Child got %q", tt.name, res.PathSegment())
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != tt.name {
+			t.Errorf("%q: GET %v answered %v %q", tt.name, path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCanonicalPathComparison(t *testing.T) {
+	h, _ := newEscapeHandler()
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		// equivalent escapings of the canonical URL are served as is
+		{"/api/items/a%3Ab", http.StatusOK, ""},
+		{"/api/items/a:b", http.StatusOK, ""},
+		{"/api/items/%e2%82%ac", http.StatusOK, ""},
+		// a trailing slash is redirected, keeping the escaped segment
+		{"/api/items/a%2Fb/", http.StatusMovedPermanently, "/api/items/a%2Fb"},
+		{"/api/items/x%20y/?q=1", http.StatusMovedPermanently, "/api/items/x%20y?q=1"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("%v: got %v %q, want %v %q", tt.path, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+
 	"github.com/sporttech/urest"
 )
 
@@ -10,13 +11,19 @@ type WithContextHandler struct {
 }
 
 func (h WithContextHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.WithContextHandler.ServeHTTP(w,r)
+	h.WithContextHandler.ServeHTTP(w, r)
 }
 
+// SetRequestData is urest.SetRequestData; wrap handlers in WithContextHandler
+// so that r carries a request data store.
 func SetRequestData(r *http.Request, name string, data interface{}) {
-	urest.SetRequestData(r,name,data)
+	urest.SetRequestData(r, name, data)
 }
 
 func GetRequestData(r *http.Request, name string) interface{} {
 	return urest.GetRequestData(r, name)
 }
+
+func RequestData[T any](r *http.Request, name string) (T, bool) {
+	return urest.RequestData[T](r, name)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sporttech/urest"
+)
+
+type (
+	// CORSOptions entries of AllowedOrigins are exact origins, "*" for any
+	// origin, or patterns with '*' wildcards such as "https://*.example.com".
+	// Empty AllowedHeaders reflect the headers the preflight asks for. "*"
+	// cannot be combined with AllowCredentials.
+	CORSOptions struct {
+		AllowedOrigins   []string
+		AllowedHeaders   []string
+		ExposedHeaders   []string
+		AllowCredentials bool
+		MaxAge           time.Duration
+	}
+
+	corsHandler struct {
+		opts     CORSOptions
+		anyOrig  bool
+		origins  map[string]bool
+		patterns []*regexp.Regexp
+		api      *urest.Handler
+		h        http.Handler
+	}
+)
+
+func NewCORSHandler(api *urest.Handler, opts CORSOptions, h http.Handler) http.Handler {
+	if h == nil {
+		h = api
+	}
+
+	c := &corsHandler{
+		opts:    opts,
+		origins: map[string]bool{},
+		api:     api,
+		h:       h,
+	}
+	for _, o := range opts.AllowedOrigins {
+		switch {
+		case o == "*":
+			c.anyOrig = true
+		case strings.Contains(o, "*"):
+			re := "(?i)^" + strings.Replace(regexp.QuoteMeta(o), `\*`, `[^/]*`, -1) + "$"
+			c.patterns = append(c.patterns, regexp.MustCompile(re))
+		default:
+			c.origins[strings.ToLower(o)] = true
+		}
+	}
+	if c.anyOrig && opts.AllowCredentials {
+		log.Panicf("CORS origin '*' cannot be combined with AllowCredentials")
+	}
+
+	return c
+}
+
+func (c *corsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+
+	if origin == "" || !c.originAllowed(origin) {
+		c.h.ServeHTTP(w, r)
+		return
+	}
+
+	if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		c.preflight(w, r, origin)
+		return
+	}
+
+	c.setOrigin(w, origin)
+	if len(c.opts.ExposedHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.opts.ExposedHeaders, ", "))
+	}
+
+	c.h.ServeHTTP(w, r)
+}
+
+func (c *corsHandler) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	res, action, err := c.api.Resolve(r)
+	if err != nil {
+		c.h.ServeHTTP(w, r)
+		return
+	}
+
+	methods := c.api.EffectiveMethods(res, action)
+	if !contains(methods, r.Header.Get("Access-Control-Request-Method")) {
+		http.Error(w, "CORS request method not allowed", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	c.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+	if len(c.opts.AllowedHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.opts.AllowedHeaders, ", "))
+	} else if rh := r.Header.Get("Access-Control-Request-Headers"); rh != "" {
+		w.Header().Set("Access-Control-Allow-Headers", rh)
+	}
+
+	if c.opts.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.opts.MaxAge/time.Second)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *corsHandler) setOrigin(w http.ResponseWriter, origin string) {
+	if c.anyOrig {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.opts.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *corsHandler) originAllowed(origin string) bool {
+	if c.anyOrig || c.origins[strings.ToLower(origin)] {
+		return true
+	}
+	for _, p := range c.patterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(arr []string, s string) bool {
+	for _, e := range arr {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
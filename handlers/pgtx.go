@@ -1,10 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
-	"sync"
 
 	_ "github.com/lib/pq"
 	"github.com/sporttech/urest"
@@ -19,6 +19,8 @@ type (
 		db *sql.DB
 		tx *sql.Tx
 	}
+
+	dbtxKey struct{}
 )
 
 const (
@@ -26,11 +28,6 @@ const (
 	dbUrl      = "postgres://"
 )
 
-var (
-	dbtxs      = map[*http.Request]*dbtx{}
-	dbtxsMutex sync.Mutex
-)
-
 func (h WithTxHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	db, err := sql.Open(driverName, dbUrl)
 	if err != nil {
@@ -41,12 +38,8 @@ func (h WithTxHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	c := &dbtx{db, nil}
 
-	dbtxsMutex.Lock()
-	dbtxs[r] = c
-	dbtxsMutex.Unlock()
-
 	tw := &TransparentResponseWriter{w, 0, 0}
-	h.Handler.ServeHTTP(tw, r)
+	h.Handler.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), dbtxKey{}, c)))
 
 	if c.tx != nil {
 		if tw.Success() && !urest.IsSafeRequest(r) {
@@ -55,16 +48,17 @@ func (h WithTxHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			c.tx.Rollback()
 		}
 	}
-
-	dbtxsMutex.Lock()
-	delete(dbtxs, r)
-	dbtxsMutex.Unlock()
 }
 
 func Tx(r *http.Request) *sql.Tx {
-	dbtxsMutex.Lock()
-	c := dbtxs[r]
-	dbtxsMutex.Unlock()
+	return TxFromContext(r.Context())
+}
+
+func TxFromContext(ctx context.Context) *sql.Tx {
+	c, ok := ctx.Value(dbtxKey{}).(*dbtx)
+	if !ok {
+		panic("No database connection in the request context, wrap the handler in WithTxHandler")
+	}
 
 	if c.tx == nil {
 		if tx, err := c.db.Begin(); err != nil {
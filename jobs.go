@@ -0,0 +1,402 @@
+package urest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	JOB_PENDING   JobStatus = "pending"
+	JOB_RUNNING   JobStatus = "running"
+	JOB_SUCCEEDED JobStatus = "succeeded"
+	JOB_FAILED    JobStatus = "failed"
+	JOB_CANCELLED JobStatus = "cancelled"
+)
+
+type (
+	JobStatus string
+
+	// JobFunc does the work of an asynchronous action. It may report progress
+	// between 0 and 1 and should return early once ctx is done.
+	JobFunc func(ctx context.Context, progress func(float64)) (interface{}, error)
+
+	Job struct {
+		ID       string      `json:"id"`
+		Status   JobStatus   `json:"status"`
+		Progress float64     `json:"progress"`
+		Result   interface{} `json:"result,omitempty"`
+		Error    *Problem    `json:"error,omitempty"`
+		Created  time.Time   `json:"created"`
+		Started  *time.Time  `json:"started,omitempty"`
+		Finished *time.Time  `json:"finished,omitempty"`
+	}
+
+	// JobStore persists jobs. Get returns nil for unknown jobs, Expire removes
+	// finished jobs that finished before the given time.
+	JobStore interface {
+		Save(*Job) error
+		Get(string) (*Job, error)
+		Delete(string) error
+		Expire(time.Time) error
+	}
+
+	MemoryJobStore struct {
+		mu   sync.Mutex
+		jobs map[string]Job
+	}
+
+	// JobManager runs jobs in a fixed pool of workers and forgets finished
+	// jobs after the retention window.
+	JobManager struct {
+		store     JobStore
+		retention time.Duration
+		queue     chan *jobTask
+		done      chan struct{}
+
+		mu      sync.Mutex
+		cancels map[string]context.CancelFunc
+		closed  bool
+	}
+
+	// AsyncActionResource resources have actions the Handler runs as jobs,
+	// answering 202 with the location of the job resource.
+	AsyncActionResource interface {
+		AsyncAction(string, *http.Request) (JobFunc, error)
+		AllowedAsyncActions() []string
+	}
+
+	jobTask struct {
+		job *Job
+		f   JobFunc
+		ctx context.Context
+	}
+
+	jobsResource struct {
+		*DefaultResourceImpl
+		m *JobManager
+	}
+
+	jobData struct {
+		m  *JobManager
+		id string
+	}
+)
+
+var (
+	ErrJobQueueFull     = NewHTTPError(http.StatusServiceUnavailable, "job_queue_full", "Too many pending jobs")
+	ErrJobManagerClosed = NewHTTPError(http.StatusServiceUnavailable, "job_manager_closed", "Jobs are no longer accepted")
+)
+
+func (j *Job) Done() bool {
+	return j.Status == JOB_SUCCEEDED || j.Status == JOB_FAILED || j.Status == JOB_CANCELLED
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{jobs: map[string]Job{}}
+}
+
+func (s *MemoryJobStore) Save(j *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = *j
+	return nil
+}
+
+func (s *MemoryJobStore) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		return &j, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryJobStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	return nil
+}
+
+func (s *MemoryJobStore) Expire(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, j := range s.jobs {
+		if j.Finished != nil && j.Finished.Before(before) {
+			delete(s.jobs, id)
+		}
+	}
+	return nil
+}
+
+// NewJobManager starts workers goroutines running jobs from a queue of
+// queueSize pending jobs. A nil store means a MemoryJobStore.
+func NewJobManager(workers int, queueSize int, store JobStore, retention time.Duration) *JobManager {
+	if store == nil {
+		store = NewMemoryJobStore()
+	}
+
+	m := &JobManager{
+		store:     store,
+		retention: retention,
+		queue:     make(chan *jobTask, queueSize),
+		done:      make(chan struct{}),
+		cancels:   map[string]context.CancelFunc{},
+	}
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	go m.expire()
+	return m
+}
+
+// Close stops the workers once the queue is drained; jobs submitted after
+// Close fail with ErrJobManagerClosed.
+func (m *JobManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+		close(m.done)
+	}
+}
+
+func (m *JobManager) Submit(f JobFunc) (*Job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	job := &Job{ID: hex.EncodeToString(id), Status: JOB_PENDING, Created: time.Now()}
+	if err := m.store.Save(job); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.cancels[job.ID] = cancel
+
+	// sending under the lock, so that Close cannot close the queue meanwhile
+	var err error
+	if m.closed {
+		err = ErrJobManagerClosed
+	} else {
+		select {
+		case m.queue <- &jobTask{job, f, ctx}:
+		default:
+			err = ErrJobQueueFull
+		}
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		m.finish(job, nil, err)
+		return nil, err
+	}
+	return job, nil
+}
+
+func (m *JobManager) Get(id string) (*Job, error) {
+	return m.store.Get(id)
+}
+
+// Cancel stops a pending or running job. Finished jobs are deleted.
+func (m *JobManager) Cancel(id string) error {
+	job, err := m.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return ErrNotFound
+	}
+	if job.Done() {
+		return m.store.Delete(id)
+	}
+	if job.Status == JOB_PENDING {
+		m.finish(job, nil, context.Canceled)
+		return nil
+	}
+
+	m.mu.Lock()
+	cancel := m.cancels[id]
+	m.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	return nil
+}
+
+func (m *JobManager) work() {
+	for t := range m.queue {
+		if t.ctx.Err() != nil {
+			// cancelled while pending
+			continue
+		}
+
+		now := time.Now()
+		t.job.Status = JOB_RUNNING
+		t.job.Started = &now
+		m.save(t.job)
+
+		progress := func(p float64) {
+			t.job.Progress = p
+			m.save(t.job)
+		}
+		res, err := m.run(t, progress)
+		if err == nil && t.ctx.Err() != nil {
+			err = t.ctx.Err()
+		}
+		m.finish(t.job, res, err)
+	}
+}
+
+func (m *JobManager) run(t *jobTask, progress func(float64)) (res interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Job %v panicked: %v", t.job.ID, p)
+			err = NewHTTPError(http.StatusInternalServerError, "job_panicked", "Job failed unexpectedly")
+		}
+	}()
+	return t.f(t.ctx, progress)
+}
+
+func (m *JobManager) finish(job *Job, res interface{}, err error) {
+	m.mu.Lock()
+	if cancel := m.cancels[job.ID]; cancel != nil {
+		cancel()
+		delete(m.cancels, job.ID)
+	}
+	m.mu.Unlock()
+
+	now := time.Now()
+	job.Finished = &now
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = JOB_CANCELLED
+	case err != nil:
+		job.Status = JOB_FAILED
+		job.Error = NewProblem(err, "", "")
+	default:
+		job.Status = JOB_SUCCEEDED
+		job.Progress = 1
+		job.Result = res
+	}
+	m.save(job)
+}
+
+func (m *JobManager) save(job *Job) {
+	if err := m.store.Save(job); err != nil {
+		log.Printf("Failed to save job %v: %v", job.ID, err)
+	}
+}
+
+func (m *JobManager) expire() {
+	if m.retention <= 0 {
+		return
+	}
+
+	t := time.NewTicker(m.retention / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-t.C:
+			if err := m.store.Expire(now.Add(-m.retention)); err != nil {
+				log.Printf("Failed to expire jobs: %v", err)
+			}
+		}
+	}
+}
+
+// SetJobManager mounts the job resources of m at /<segment>/<id> under the
+// root and enables asynchronous actions.
+func (h *Handler) SetJobManager(m *JobManager, segment string) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	if h.res.Child(segment, r) != nil {
+		log.Panicf("Resource '%v' already has a child '%v'", relativeURL(h.res), segment)
+	}
+
+	jobs := &jobsResource{NewDefaultResourceImpl(h.res, segment), m}
+	jobs.IsCollection_ = true
+	h.jobs = jobs
+	h.mounts[segment] = jobs
+}
+
+// AddAsyncAction adds an action run as a job (see Handler.SetJobManager). f
+// checks the request and returns the work to do, which must not use the
+// request after f returns.
+func (d *DefaultResourceImpl) AddAsyncAction(action string, f func(*http.Request) (JobFunc, error)) {
+	d.AsyncActions[action] = f
+}
+
+func (d *DefaultResourceImpl) AsyncAction(action string, r *http.Request) (JobFunc, error) {
+	if a := d.AsyncActions[action]; a != nil {
+		return a(r)
+	}
+	return nil, NewHTTPError(http.StatusBadRequest, "unknown_action", "Unknown action")
+}
+
+func (d *DefaultResourceImpl) AllowedAsyncActions() []string {
+	r := make([]string, 0, len(d.AsyncActions))
+	for a := range d.AsyncActions {
+		r = append(r, a)
+	}
+	return r
+}
+
+func (h *Handler) submitJob(res Resource, f JobFunc, w http.ResponseWriter, r *http.Request) {
+	if h.jobs == nil {
+		h.reportError(w, r, res, NewHTTPError(http.StatusNotImplemented, "no_job_manager", "Asynchronous actions are not enabled"))
+		return
+	}
+
+	job, err := h.jobs.m.Submit(f)
+	if err != nil {
+		h.reportError(w, r, res, err)
+		return
+	}
+
+	w.Header().Set("Location", RelativeURL(h.prefix, h.jobs.job(job.ID)).String())
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (j *jobsResource) Child(name string, r *http.Request) Resource {
+	if job, err := j.m.Get(name); err != nil || job == nil {
+		return nil
+	}
+	return j.job(name)
+}
+
+func (j *jobsResource) job(id string) Resource {
+	ch := NewDefaultResourceImpl(j, id)
+	ch.SetDataDelegate(jobData{j.m, id})
+	ch.addMethods("DELETE")
+	return ch
+}
+
+func (j *jobsResource) Delete(name string, r *http.Request) error {
+	return j.m.Cancel(name)
+}
+
+func (jd jobData) Data(prefix string, r *http.Request) (interface{}, error) {
+	job, err := jd.m.Get(jd.id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+func (jd jobData) LiveData(prefix string, r *http.Request) (interface{}, error) {
+	return jd.Data(prefix, r)
+}
@@ -0,0 +1,85 @@
+package urest
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+type (
+	acceptRange struct {
+		mediaType string
+		q         float64
+	}
+)
+
+func parseAccept(header string) []acceptRange {
+	ranges := make([]acceptRange, 0)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		if mt == "*" {
+			mt = "*/*"
+		}
+
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(qs, 64); err == nil {
+				q = v
+			}
+		}
+		ranges = append(ranges, acceptRange{mt, q})
+	}
+	return ranges
+}
+
+func (a acceptRange) specificity(mediaType string) int {
+	switch {
+	case a.mediaType == mediaType:
+		return 3
+	case strings.HasSuffix(a.mediaType, "/*") && strings.HasPrefix(mediaType, a.mediaType[:len(a.mediaType)-1]):
+		return 2
+	case a.mediaType == "*/*":
+		return 1
+	}
+	return 0
+}
+
+// negotiate picks the offered media type the Accept header prefers most; ties
+// go to the earlier offer. An empty header accepts the first offer.
+func negotiate(accept string, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	ranges := parseAccept(accept)
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		mt := offer
+		if parsed, _, err := mime.ParseMediaType(offer); err == nil {
+			mt = parsed
+		}
+
+		q, spec := 0.0, 0
+		for _, a := range ranges {
+			if s := a.specificity(mt); s > spec {
+				q, spec = a.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
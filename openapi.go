@@ -0,0 +1,464 @@
+package urest
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	OpenAPIInfo struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description,omitempty"`
+	}
+
+	OpenAPIDocument struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       OpenAPIInfo                             `json:"info"`
+		Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+		Components OpenAPIComponents                       `json:"components"`
+	}
+
+	OpenAPIComponents struct {
+		Schemas map[string]*JSONSchema `json:"schemas"`
+	}
+
+	OpenAPIOperation struct {
+		Summary     string                      `json:"summary,omitempty"`
+		OperationID string                      `json:"operationId,omitempty"`
+		Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+		RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+		Responses   map[string]*OpenAPIResponse `json:"responses"`
+	}
+
+	OpenAPIParameter struct {
+		Name     string      `json:"name"`
+		In       string      `json:"in"`
+		Required bool        `json:"required,omitempty"`
+		Schema   *JSONSchema `json:"schema"`
+	}
+
+	OpenAPIRequestBody struct {
+		Required bool                         `json:"required,omitempty"`
+		Content  map[string]*OpenAPIMediaType `json:"content"`
+	}
+
+	OpenAPIResponse struct {
+		Description string                       `json:"description"`
+		Headers     map[string]*OpenAPIHeader    `json:"headers,omitempty"`
+		Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+	}
+
+	OpenAPIHeader struct {
+		Description string      `json:"description,omitempty"`
+		Schema      *JSONSchema `json:"schema"`
+	}
+
+	OpenAPIMediaType struct {
+		Schema *JSONSchema `json:"schema,omitempty"`
+	}
+
+	openAPIData struct {
+		root Resource
+		info OpenAPIInfo
+		self Resource
+	}
+
+	schemaGenerator struct {
+		components map[string]*JSONSchema
+		names      map[reflect.Type]string
+		types      map[string]reflect.Type
+	}
+)
+
+var (
+	// package paths inside the type arguments of generic type names
+	typeArgPath = regexp.MustCompile(`[^\[\],*]*/`)
+	// OpenAPI component names must match ^[a-zA-Z0-9._-]+$
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+)
+
+// GenerateOpenAPI describes the resource tree under root, mounted at prefix,
+// as an OpenAPI 3.1 document.
+func GenerateOpenAPI(root Resource, prefix string, info OpenAPIInfo) *OpenAPIDocument {
+	return generateOpenAPI(root, prefix, info, nil)
+}
+
+// NewOpenAPIResource serves the OpenAPI document of the tree under root.
+func NewOpenAPIResource(parent Resource, pathSegment string, root Resource, info OpenAPIInfo) *DefaultResourceImpl {
+	d := NewDefaultResourceImpl(parent, pathSegment)
+	d.SetDataDelegate(&openAPIData{root, info, d})
+	return d
+}
+
+func (o *openAPIData) Data(prefix string, r *http.Request) (interface{}, error) {
+	return generateOpenAPI(o.root, prefix, o.info, o.self), nil
+}
+
+func (o *openAPIData) LiveData(prefix string, r *http.Request) (interface{}, error) {
+	return o.Data(prefix, r)
+}
+
+func generateOpenAPI(root Resource, prefix string, info OpenAPIInfo, skip Resource) *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI:    "3.1.0",
+		Info:       info,
+		Paths:      map[string]map[string]*OpenAPIOperation{},
+		Components: OpenAPIComponents{Schemas: map[string]*JSONSchema{}},
+	}
+	sg := &schemaGenerator{doc.Components.Schemas, map[reflect.Type]string{}, map[string]reflect.Type{}}
+	sg.components["Problem"] = problemSchema()
+	sg.types["Problem"] = nil
+
+	walkTree(root, prefix, func(n treeNode) {
+		if skip != nil && n.res == skip {
+			return
+		}
+
+		ops := map[string]*OpenAPIOperation{}
+		desc := describe(n.res)
+		params := pathParameters(n.params)
+
+		for _, m := range n.res.AllowedMethods() {
+			op := sg.operation(n.res, desc, m)
+			if op == nil {
+				continue
+			}
+			op.Summary = desc.Summary
+			op.OperationID = operationID(m, n.path)
+			op.Parameters = append(append([]*OpenAPIParameter{}, params...), op.Parameters...)
+			op.Responses["default"] = errorResponse()
+			ops[strings.ToLower(m)] = op
+		}
+		if len(ops) > 0 {
+			doc.Paths[n.path] = ops
+		}
+
+		if index(n.res.AllowedMethods(), "POST") == -1 {
+			return
+		}
+		actions := append([]string{}, n.res.AllowedActions()...)
+		sort.Strings(actions)
+		for _, a := range actions {
+			p := strings.TrimSuffix(n.path, "/") + "/" + a
+			op := &OpenAPIOperation{
+				OperationID: operationID("POST", p),
+				Parameters:  params,
+				Responses: map[string]*OpenAPIResponse{
+					"204":     {Description: "Action performed"},
+					"default": errorResponse(),
+				},
+			}
+			if _, ok := n.res.(ActionResource); ok {
+				op.Responses["200"] = &OpenAPIResponse{Description: "Action result"}
+			}
+			doc.Paths[p] = map[string]*OpenAPIOperation{"post": op}
+		}
+	})
+
+	return doc
+}
+
+func (sg *schemaGenerator) operation(res Resource, desc Description, method string) *OpenAPIOperation {
+	op := &OpenAPIOperation{Responses: map[string]*OpenAPIResponse{}}
+
+	switch method {
+	case "GET", "HEAD":
+		ok := &OpenAPIResponse{Description: "Current representation", Headers: cacheHeaders(res)}
+		if method == "GET" {
+			ok.Content = sg.representations(res, desc.DataType)
+		}
+		op.Responses["200"] = ok
+		if _, hasETag := ok.Headers["ETag"]; hasETag {
+			op.Responses["304"] = &OpenAPIResponse{Description: "Not modified"}
+		}
+		if paginated(res) {
+			for _, p := range []string{"limit", "offset", "cursor", "filter", "sort", "fields"} {
+				t := "string"
+				if p == "limit" || p == "offset" {
+					t = "integer"
+				}
+				op.Parameters = append(op.Parameters, &OpenAPIParameter{Name: p, In: "query", Schema: &JSONSchema{Type: t}})
+			}
+			ok.Headers["Link"] = &OpenAPIHeader{Schema: &JSONSchema{Type: "string"}}
+			ok.Headers["X-Total-Count"] = &OpenAPIHeader{Schema: &JSONSchema{Type: "integer"}}
+		}
+	case "PUT":
+		op.RequestBody = sg.requestBody([]string{"application/json"}, desc.BodyType)
+		op.Responses["204"] = &OpenAPIResponse{Description: "Replaced"}
+	case "PATCH":
+		op.RequestBody = sg.requestBody(acceptPatch(res), desc.BodyType)
+		op.Responses["204"] = &OpenAPIResponse{Description: "Updated"}
+	case "POST":
+		if !res.IsCollection() {
+			return nil
+		}
+		op.RequestBody = sg.requestBody(acceptPost(res), desc.CreateType)
+		op.Responses["201"] = &OpenAPIResponse{
+			Description: "Created",
+			Headers:     map[string]*OpenAPIHeader{"Location": {Schema: &JSONSchema{Type: "string"}}},
+		}
+	case "DELETE":
+		op.Responses["204"] = &OpenAPIResponse{Description: "Deleted"}
+	default:
+		return nil
+	}
+	return op
+}
+
+func (sg *schemaGenerator) representations(res Resource, t reflect.Type) map[string]*OpenAPIMediaType {
+	content := map[string]*OpenAPIMediaType{}
+	if t == nil {
+		content[mediaType(res.ContentType())] = &OpenAPIMediaType{}
+		return content
+	}
+
+	s := sg.schema(t)
+	for _, e := range Encoders() {
+		content[mediaType(e.ContentType())] = &OpenAPIMediaType{Schema: s}
+	}
+	return content
+}
+
+func (sg *schemaGenerator) requestBody(types []string, t reflect.Type) *OpenAPIRequestBody {
+	rb := &OpenAPIRequestBody{Required: true, Content: map[string]*OpenAPIMediaType{}}
+	s := (*JSONSchema)(nil)
+	if t != nil {
+		s = sg.schema(t)
+	}
+	for _, mt := range types {
+		if mt == CONTENT_TYPE_JSON_PATCH {
+			rb.Content[mt] = &OpenAPIMediaType{Schema: &JSONSchema{Type: "array", Items: &JSONSchema{Type: "object"}}}
+		} else {
+			rb.Content[mt] = &OpenAPIMediaType{Schema: s}
+		}
+	}
+	return rb
+}
+
+func paginated(res Resource) bool {
+	if _, ok := res.(PaginatedCollection); ok && res.IsCollection() {
+		return true
+	}
+	d := defaultImpl(res)
+	return d != nil && d.list != nil
+}
+
+func cacheHeaders(res Resource) map[string]*OpenAPIHeader {
+	str := &JSONSchema{Type: "string"}
+	probe, _ := http.NewRequest("GET", "/", nil)
+	h := map[string]*OpenAPIHeader{}
+
+	cc := res.CacheControl()
+	if cc == "" {
+		cc = "no-cache, must-revalidate"
+	}
+	h["Cache-Control"] = &OpenAPIHeader{Description: cc, Schema: str}
+
+	if !res.Expires().IsZero() {
+		h["Expires"] = &OpenAPIHeader{Schema: str}
+	}
+	if _, ok := res.(LastModifiedResource); ok {
+		h["Last-Modified"] = &OpenAPIHeader{Schema: str}
+	}
+	if d := defaultImpl(res); (d != nil && d.HashETag) || res.ETag(probe) != "" {
+		h["ETag"] = &OpenAPIHeader{Schema: str}
+	}
+	return h
+}
+
+func errorResponse() *OpenAPIResponse {
+	return &OpenAPIResponse{
+		Description: "Error",
+		Content: map[string]*OpenAPIMediaType{
+			CONTENT_TYPE_PROBLEM_JSON: {Schema: &JSONSchema{Ref: "#/components/schemas/Problem"}},
+		},
+	}
+}
+
+func problemSchema() *JSONSchema {
+	str := &JSONSchema{Type: "string"}
+	return &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"type":     str,
+			"title":    str,
+			"status":   {Type: "integer"},
+			"detail":   str,
+			"instance": str,
+			"code":     str,
+			"details":  {},
+		},
+		Required: []string{"type", "title", "status"},
+	}
+}
+
+func pathParameters(names []string) []*OpenAPIParameter {
+	params := make([]*OpenAPIParameter, 0, len(names))
+	for _, n := range names {
+		params = append(params, &OpenAPIParameter{Name: n, In: "path", Required: true, Schema: &JSONSchema{Type: "string"}})
+	}
+	return params
+}
+
+func operationID(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, part := range strings.Split(path, "/") {
+		part = strings.Trim(part, "{}")
+		if part != "" {
+			id += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return id
+}
+
+// schema converts t into a JSON schema, registering named struct types as
+// components.
+func (sg *schemaGenerator) schema(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", Format: "byte"}
+		}
+		return &JSONSchema{Type: "array", Items: sg.schema(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: sg.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return sg.structSchema(t)
+		}
+		name, ok := sg.names[t]
+		if !ok {
+			name = sg.componentName(t)
+			// placeholder first, so that recursive types terminate
+			sg.components[name] = &JSONSchema{}
+			*sg.components[name] = *sg.structSchema(t)
+		}
+		return &JSONSchema{Ref: "#/components/schemas/" + name}
+	}
+	return &JSONSchema{}
+}
+
+// componentName is the sanitized type name, qualified by its package (and
+// then by its full package path) if another type already took it.
+func (sg *schemaGenerator) componentName(t reflect.Type) string {
+	base := typeArgPath.ReplaceAllString(t.Name(), "")
+	candidates := []string{
+		base,
+		path.Base(t.PkgPath()) + "." + base,
+		t.PkgPath() + "." + base,
+	}
+
+	name := ""
+	for _, c := range candidates {
+		name = strings.Trim(invalidNameChars.ReplaceAllString(c, "_"), "_")
+		if _, taken := sg.types[name]; !taken {
+			break
+		}
+	}
+	unique := name
+	for i := 2; ; i++ {
+		if _, taken := sg.types[unique]; !taken {
+			break
+		}
+		unique = name + "_" + strconv.Itoa(i)
+	}
+
+	sg.names[t] = unique
+	sg.types[unique] = t
+	return unique
+}
+
+func (sg *schemaGenerator) structSchema(t reflect.Type) *JSONSchema {
+	s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := jsonFieldName(f)
+		if name == "-" {
+			continue
+		}
+
+		fs := sg.schema(f.Type)
+		if tag := f.Tag.Get("validate"); tag != "" {
+			if fs.Ref != "" {
+				fs = &JSONSchema{Ref: fs.Ref}
+			}
+			if applyValidateTag(fs, tag) {
+				s.Required = append(s.Required, name)
+			}
+		}
+		s.Properties[name] = fs
+	}
+	return s
+}
+
+// applyValidateTag maps validate struct tag rules onto s and reports whether
+// the field is required.
+func applyValidateTag(s *JSONSchema, tag string) bool {
+	required := false
+	for _, rule := range splitRules(tag) {
+		name, arg := rule[0], rule[1]
+		switch name {
+		case "required":
+			required = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				continue
+			}
+			ni := int(n)
+			switch {
+			case s.Type == "string" && name == "min":
+				s.MinLength = &ni
+			case s.Type == "string":
+				s.MaxLength = &ni
+			case s.Type == "array" && name == "min":
+				s.MinItems = &ni
+			case s.Type == "array":
+				s.MaxItems = &ni
+			case name == "min":
+				s.Minimum = &n
+			default:
+				s.Maximum = &n
+			}
+		case "enum":
+			for _, e := range strings.Split(arg, "|") {
+				if n, err := strconv.ParseFloat(e, 64); err == nil && (s.Type == "integer" || s.Type == "number") {
+					s.Enum = append(s.Enum, n)
+				} else {
+					s.Enum = append(s.Enum, e)
+				}
+			}
+		case "pattern":
+			s.Pattern = arg
+		}
+	}
+	return required
+}
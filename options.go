@@ -0,0 +1,119 @@
+package urest
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type (
+	PatchAcceptor interface {
+		AcceptPatch() []string
+	}
+
+	PostAcceptor interface {
+		AcceptPost() []string
+	}
+)
+
+// EffectiveMethods lists the methods the Handler answers for res, or for the
+// trailing name under res when action is not nil: POST if it is an action,
+// PUT if res is a PutCollection that may create it. POST to a non-collection
+// without actions is left out, see Handler.EffectiveMethods.
+func EffectiveMethods(res Resource, action *string) []string {
+	if action != nil {
+		methods := []string{"OPTIONS"}
+		if index(res.AllowedActions(), *action) != -1 && index(res.AllowedMethods(), "POST") != -1 {
+			methods = append(methods, "POST")
+		}
+		if _, ok := res.(PutCollection); ok && res.IsCollection() {
+			methods = append(methods, "PUT")
+		}
+		return methods
+	}
+
+	methods := make([]string, 0, len(res.AllowedMethods())+1)
+	for _, m := range res.AllowedMethods() {
+		if m != "POST" || res.IsCollection() || len(res.AllowedActions()) > 0 {
+			methods = append(methods, m)
+		}
+	}
+	if index(methods, "OPTIONS") == -1 {
+		methods = append(methods, "OPTIONS")
+	}
+	return methods
+}
+
+// EffectiveMethods is EffectiveMethods, with POST on non-collections that
+// allow PUT when legacy POST replaces are on.
+func (h *Handler) EffectiveMethods(res Resource, action *string) []string {
+	methods := EffectiveMethods(res, action)
+	if h.legacyPostReplace && action == nil && !res.IsCollection() && index(methods, "PUT") != -1 && index(methods, "POST") == -1 {
+		methods = append(methods, "POST")
+	}
+	return methods
+}
+
+func (h *Handler) options(res Resource, action *string, w http.ResponseWriter, r *http.Request) {
+	methods := h.EffectiveMethods(res, action)
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+
+	if action == nil {
+		actions := append([]string{}, res.AllowedActions()...)
+		sort.Strings(actions)
+		for _, a := range actions {
+			w.Header().Add("Link", fmt.Sprintf("<%v>; rel=\"action\"; title=\"%v\"", ActionURL(h.prefix, res, a), a))
+		}
+		if qr, ok := res.(QueryResource); ok {
+			for _, name := range qr.AllowedQueries() {
+				if q := qr.Query(name, r); q != nil {
+					w.Header().Add("Link", fmt.Sprintf("<%v>; rel=\"query\"; title=\"%v\"", RelativeURL(h.prefix, q), name))
+				}
+			}
+		}
+
+		if index(methods, "PATCH") != -1 {
+			w.Header().Set("Accept-Patch", strings.Join(acceptPatch(res), ", "))
+		}
+		if res.IsCollection() && index(methods, "POST") != -1 {
+			w.Header().Set("Accept-Post", strings.Join(acceptPost(res), ", "))
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func acceptPatch(res Resource) []string {
+	if pa, ok := res.(PatchAcceptor); ok {
+		return pa.AcceptPatch()
+	}
+	return []string{mediaType(res.ContentType())}
+}
+
+func acceptPost(res Resource) []string {
+	if pa, ok := res.(PostAcceptor); ok {
+		return pa.AcceptPost()
+	}
+	return []string{mediaType(res.ContentType())}
+}
+
+func mediaType(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt
+	}
+	return "application/json"
+}
+
+func ActionURL(prefix string, res Resource, action string) *url.URL {
+	u := RelativeURL(prefix, res)
+	sep := "/"
+	if strings.HasSuffix(u.Path, "/") {
+		sep = ""
+	}
+	u.Path += sep + action
+	u.RawPath += sep + url.PathEscape(action)
+	return u
+}
@@ -0,0 +1,228 @@
+package urest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+type (
+	// PageRequest is parsed from the limit, cursor and offset query
+	// parameters. A zero Limit leaves the page size to the collection, which
+	// is expected to apply Query too (field selection is done for it).
+	PageRequest struct {
+		Limit  int
+		Cursor string
+		Offset int
+		Query  CollectionQuery
+	}
+
+	// Page is a slice of the collection in Items. Collections paging by
+	// cursor set Next and Prev; otherwise the Handler links pages by offset.
+	Page struct {
+		Items    interface{}
+		Next     string
+		Prev     string
+		Total    int
+		HasTotal bool
+	}
+
+	Pager interface {
+		List(context.Context, PageRequest) (*Page, error)
+	}
+
+	// PaginatedCollection collections answer GET with a page of items and
+	// RFC 8288 Link headers to the first, next and previous pages.
+	PaginatedCollection interface {
+		Collection
+		Pager
+	}
+
+	typedPager[T any] struct {
+		list func(context.Context) ([]T, error)
+	}
+)
+
+var MaxPageLimit = 1000
+
+func ParsePageRequest(r *http.Request) (PageRequest, error) {
+	q := r.URL.Query()
+	req := PageRequest{Cursor: q.Get("cursor")}
+
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return req, NewHTTPError(http.StatusBadRequest, "invalid_page", fmt.Sprintf("Invalid limit '%v'", s))
+		}
+		req.Limit = n
+		if req.Limit > MaxPageLimit {
+			req.Limit = MaxPageLimit
+		}
+	}
+
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return req, NewHTTPError(http.StatusBadRequest, "invalid_page", fmt.Sprintf("Invalid offset '%v'", s))
+		}
+		if req.Cursor != "" {
+			return req, NewHTTPError(http.StatusBadRequest, "invalid_page", "Both cursor and offset given")
+		}
+		req.Offset = n
+	}
+
+	cq, err := ParseCollectionQuery(r)
+	req.Query = cq
+	return req, err
+}
+
+// SetListDelegate makes GET answer with pages from del, see
+// PaginatedCollection.
+func (d *DefaultResourceImpl) SetListDelegate(del Pager) {
+	if EncoderFor(d.ContentType()) == nil {
+		panic("Resource has List function but no encoder for its Content-Type")
+	}
+
+	d.addMethods("GET")
+	d.list = del
+}
+
+func (h *Handler) list(res Resource, pc Pager, w http.ResponseWriter, r *http.Request) error {
+	def := EncoderFor(res.ContentType())
+	if def == nil {
+		def = EncoderFor(CONTENT_TYPE_JSON)
+	}
+	enc, err := NegotiateEncoder(r, def)
+	if err != nil {
+		return err
+	}
+
+	body, err := listPage(pc, RelativeURL(h.prefix, res), w, r, enc)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Vary", "Accept")
+	w.Write(body)
+	return nil
+}
+
+// listPage lists the page the request asks for, selects the requested fields
+// and sets the Link headers relative to base.
+func listPage(pc Pager, base *url.URL, w http.ResponseWriter, r *http.Request, enc Encoder) ([]byte, error) {
+	req, err := ParsePageRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	page, err := pc.List(r.Context(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := req.Query.Select(pageItems(page))
+	if err != nil {
+		return nil, err
+	}
+	body, err := enc.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	setPageHeaders(w, r, base, req, page)
+	return body, nil
+}
+
+func pageItems(page *Page) interface{} {
+	if page.Items == nil {
+		return []interface{}{}
+	}
+	return page.Items
+}
+
+func setPageHeaders(w http.ResponseWriter, r *http.Request, base *url.URL, req PageRequest, page *Page) {
+	link := func(rel string, set func(url.Values)) {
+		q := r.URL.Query()
+		q.Del("cursor")
+		q.Del("offset")
+		set(q)
+
+		u := *base
+		u.RawQuery = q.Encode()
+		w.Header().Add("Link", fmt.Sprintf("<%v>; rel=\"%v\"", u.String(), rel))
+	}
+
+	link("first", func(url.Values) {})
+
+	if page.Next != "" || page.Prev != "" {
+		if page.Next != "" {
+			link("next", func(q url.Values) { q.Set("cursor", page.Next) })
+		}
+		if page.Prev != "" {
+			link("prev", func(q url.Values) { q.Set("cursor", page.Prev) })
+		}
+	} else if req.Cursor == "" {
+		n := 0
+		if v := reflect.ValueOf(page.Items); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			n = v.Len()
+		}
+
+		more := page.HasTotal && req.Offset+n < page.Total || !page.HasTotal && req.Limit > 0 && n == req.Limit
+		if more && n > 0 {
+			link("next", func(q url.Values) { q.Set("offset", strconv.Itoa(req.Offset+n)) })
+		}
+		if req.Offset > 0 {
+			prev := req.Offset - req.Limit
+			if req.Limit == 0 || prev < 0 {
+				prev = 0
+			}
+			link("prev", func(q url.Values) {
+				if prev > 0 {
+					q.Set("offset", strconv.Itoa(prev))
+				}
+			})
+		}
+	}
+
+	if page.HasTotal {
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	}
+}
+
+// List filters, sorts and pages through all items in memory by offset.
+func (p typedPager[T]) List(ctx context.Context, req PageRequest) (*Page, error) {
+	if req.Cursor != "" {
+		return nil, NewHTTPError(http.StatusBadRequest, "invalid_page", "Collection pages by offset, not by cursor")
+	}
+
+	items, err := p.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(req.Query.Filters) > 0 || len(req.Query.Sort) > 0 {
+		applied, err := req.Query.Apply(items)
+		if err != nil {
+			return nil, err
+		}
+		return offsetPage(applied, req), nil
+	}
+	return offsetPage(items, req), nil
+}
+
+func offsetPage[T any](items []T, req PageRequest) *Page {
+	offset, total := req.Offset, len(items)
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if req.Limit > 0 && offset+req.Limit < total {
+		end = offset + req.Limit
+	}
+
+	return &Page{Items: items[offset:end], Total: total, HasTotal: true}
+}
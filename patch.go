@@ -0,0 +1,378 @@
+package urest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	CONTENT_TYPE_MERGE_PATCH = "application/merge-patch+json"
+	CONTENT_TYPE_JSON_PATCH  = "application/json-patch+json"
+)
+
+type (
+	patchOp struct {
+		Op    string          `json:"op"`
+		Path  *string         `json:"path"`
+		From  *string         `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+)
+
+// ApplyPatch applies a patch document of the given media type (merge patch
+// or JSON patch) to the JSON document doc.
+func ApplyPatch(mediaType string, doc []byte, patch []byte) ([]byte, error) {
+	switch mediaType {
+	case CONTENT_TYPE_MERGE_PATCH:
+		return MergePatch(doc, patch)
+	case CONTENT_TYPE_JSON_PATCH:
+		return JSONPatch(doc, patch)
+	}
+
+	return nil, unsupportedPatch(mediaType, []string{CONTENT_TYPE_MERGE_PATCH, CONTENT_TYPE_JSON_PATCH})
+}
+
+func unsupportedPatch(mediaType string, accepted []string) error {
+	err := NewHTTPError(http.StatusUnsupportedMediaType, "unsupported_patch", fmt.Sprintf("Unsupported patch type '%v'", mediaType))
+	err.Header = http.Header{"Accept-Patch": {strings.Join(accepted, ", ")}}
+	return err
+}
+
+// MergePatch implements RFC 7396.
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decodeGeneric(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeGeneric(patch)
+	if err != nil {
+		return nil, WrapHTTPError(http.StatusBadRequest, "invalid_patch", err)
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	tm, ok := target.(map[string]interface{})
+	if !ok {
+		tm = map[string]interface{}{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+		} else {
+			tm[k] = mergePatch(tm[k], v)
+		}
+	}
+	return tm
+}
+
+// JSONPatch implements RFC 6902. Failed "test" operations are reported as
+// 409 Conflict, operations on missing locations as 422.
+func JSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decodeGeneric(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]patchOp, 0)
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, WrapHTTPError(http.StatusBadRequest, "invalid_patch", err)
+	}
+
+	for i, op := range ops {
+		if target, err = applyPatchOp(target, op); err != nil {
+			var he *HTTPError
+			if !errors.As(err, &he) {
+				he = WrapHTTPError(http.StatusUnprocessableEntity, "patch_failed", err)
+			}
+			return nil, he.WithDetails(map[string]interface{}{"operation": i})
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyPatchOp(doc interface{}, op patchOp) (interface{}, error) {
+	if op.Path == nil {
+		return nil, NewHTTPError(http.StatusBadRequest, "invalid_patch", fmt.Sprintf("Operation '%v' has no path", op.Op))
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	value := func() (interface{}, error) {
+		if op.Value == nil {
+			return nil, NewHTTPError(http.StatusBadRequest, "invalid_patch", fmt.Sprintf("Operation '%v' has no value", op.Op))
+		}
+		return decodeGeneric(op.Value)
+	}
+	from := func() ([]string, error) {
+		if op.From == nil {
+			return nil, NewHTTPError(http.StatusBadRequest, "invalid_patch", fmt.Sprintf("Operation '%v' has no from", op.Op))
+		}
+		return parsePointer(*op.From)
+	}
+
+	switch op.Op {
+	case "add", "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return setPointer(doc, path, v, op.Op == "add")
+	case "remove":
+		d, _, err := removePointer(doc, path)
+		return d, err
+	case "move", "copy":
+		f, err := from()
+		if err != nil {
+			return nil, err
+		}
+		v, err := getPointer(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+				return nil, fmt.Errorf("Cannot move '%v' into its own child '%v'", *op.From, *op.Path)
+			}
+			if doc, _, err = removePointer(doc, f); err != nil {
+				return nil, err
+			}
+		} else if v, err = deepCopy(v); err != nil {
+			return nil, err
+		}
+		return setPointer(doc, path, v, true)
+	case "test":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		cur, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(cur, v) {
+			return nil, NewHTTPError(http.StatusConflict, "patch_test_failed", fmt.Sprintf("Test of '%v' failed", *op.Path))
+		}
+		return doc, nil
+	}
+
+	return nil, NewHTTPError(http.StatusBadRequest, "invalid_patch", fmt.Sprintf("Unknown operation '%v'", op.Op))
+}
+
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, NewHTTPError(http.StatusBadRequest, "invalid_patch", fmt.Sprintf("Invalid JSON pointer '%v'", p))
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("Invalid array index '%v'", token)
+	}
+
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if i > max {
+		return 0, fmt.Errorf("Array index %v out of range", i)
+	}
+	return i, nil
+}
+
+func getPointer(doc interface{}, path []string) (interface{}, error) {
+	for _, t := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, fmt.Errorf("Member '%v' not found", t)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, fmt.Errorf("Cannot descend into '%v'", t)
+		}
+	}
+	return doc, nil
+}
+
+// modifyPointer calls op with the container holding the last path token and
+// stores the container op returns back into the document.
+func modifyPointer(doc interface{}, path []string, op func(interface{}, string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return op(doc, path[0])
+	}
+
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		ch, ok := c[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("Member '%v' not found", path[0])
+		}
+		n, err := modifyPointer(ch, path[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		c[path[0]] = n
+		return c, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(c), false)
+		if err != nil {
+			return nil, err
+		}
+		n, err := modifyPointer(c[i], path[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = n
+		return c, nil
+	}
+	return nil, fmt.Errorf("Cannot descend into '%v'", path[0])
+}
+
+func setPointer(doc interface{}, path []string, v interface{}, add bool) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+
+	return modifyPointer(doc, path, func(container interface{}, key string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, ok := c[key]; !ok && !add {
+				return nil, fmt.Errorf("Member '%v' not found", key)
+			}
+			c[key] = v
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(c), add)
+			if err != nil {
+				return nil, err
+			}
+			if !add {
+				c[i] = v
+				return c, nil
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = v
+			return c, nil
+		}
+		return nil, fmt.Errorf("Cannot set '%v' in a scalar", key)
+	})
+}
+
+func removePointer(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("Cannot remove the whole document")
+	}
+
+	removed := interface{}(nil)
+	d, err := modifyPointer(doc, path, func(container interface{}, key string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			v, ok := c[key]
+			if !ok {
+				return nil, fmt.Errorf("Member '%v' not found", key)
+			}
+			removed = v
+			delete(c, key)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = c[i]
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("Cannot remove '%v' from a scalar", key)
+	})
+	return d, removed, err
+}
+
+func decodeGeneric(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v := interface{}(nil)
+	err := dec.Decode(&v)
+	return v, err
+}
+
+func deepCopy(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGeneric(b)
+}
+
+func jsonEqual(a interface{}, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, err1 := an.Float64()
+		bf, err2 := bn.Float64()
+		return err1 == nil && err2 == nil && af == bf
+	}
+
+	switch at := a.(type) {
+	case map[string]interface{}:
+		bt, ok := b.(map[string]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for k, v := range at {
+			if bv, ok := bt[k]; !ok || !jsonEqual(v, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bt, ok := b.([]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for i := range at {
+			if !jsonEqual(at[i], bt[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,105 @@
+package urest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func sameJSON(a string, b string) bool {
+	var x, y interface{}
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// RFC 7396, appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil || !sameJSON(string(got), tt.want) {
+			t.Errorf("%v + %v: got %s, %v, want %v", tt.doc, tt.patch, got, err, tt.want)
+		}
+	}
+}
+
+// RFC 6902, appendix A, and array index edge cases
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+	}{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"add element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"move member", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"move element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"test", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{"add nested member", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{"ignore unknown members", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{"escapes", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{"add array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{"copy", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+		{"add at length", `{"foo":[1,2]}`, `[{"op":"add","path":"/foo/2","value":3}]`, `{"foo":[1,2,3]}`},
+		{"add at start", `{"foo":[1,2]}`, `[{"op":"add","path":"/foo/0","value":0}]`, `{"foo":[0,1,2]}`},
+		{"replace root", `{"foo":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+		{"remove last", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":[1]}`},
+	}
+
+	for _, tt := range tests {
+		got, err := JSONPatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil || !sameJSON(string(got), tt.want) {
+			t.Errorf("%v: got %s, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		name, doc, patch string
+		status           int
+	}{
+		{"failed test", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, http.StatusConflict},
+		{"string is not number", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, http.StatusConflict},
+		{"missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, http.StatusUnprocessableEntity},
+		{"index past length", `{"foo":[1,2]}`, `[{"op":"add","path":"/foo/3","value":3}]`, http.StatusUnprocessableEntity},
+		{"replace at length", `{"foo":[1,2]}`, `[{"op":"replace","path":"/foo/2","value":3}]`, http.StatusUnprocessableEntity},
+		{"remove end", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/-"}]`, http.StatusUnprocessableEntity},
+		{"leading zero", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/01"}]`, http.StatusUnprocessableEntity},
+		{"negative index", `{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/-1"}]`, http.StatusUnprocessableEntity},
+		{"remove missing", `{"foo":1}`, `[{"op":"remove","path":"/bar"}]`, http.StatusUnprocessableEntity},
+		{"move into itself", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/c"}]`, http.StatusUnprocessableEntity},
+		{"no path", `{}`, `[{"op":"add","value":1}]`, http.StatusBadRequest},
+		{"unknown op", `{}`, `[{"op":"frob","path":"/a"}]`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		_, err := JSONPatch([]byte(tt.doc), []byte(tt.patch))
+		if ErrorStatus(err) != tt.status {
+			t.Errorf("%v: got %v (%v), want %v", tt.name, ErrorStatus(err), err, tt.status)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package urest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	CONTENT_TYPE_PROBLEM_JSON = "application/problem+json"
+)
+
+type (
+	ErrorRenderer interface {
+		RenderError(w http.ResponseWriter, r *http.Request, instance string, err error)
+	}
+
+	Problem struct {
+		Type     string      `json:"type"`
+		Title    string      `json:"title"`
+		Status   int         `json:"status"`
+		Detail   string      `json:"detail,omitempty"`
+		Instance string      `json:"instance,omitempty"`
+		Code     string      `json:"code,omitempty"`
+		Details  interface{} `json:"details,omitempty"`
+	}
+
+	// ProblemErrorRenderer answers with RFC 7807 documents to clients that
+	// accept JSON and with plain text to everyone else. When TypeBase is set,
+	// problem types are TypeBase followed by the HTTPError code.
+	ProblemErrorRenderer struct {
+		TypeBase string
+	}
+
+	TextErrorRenderer struct{}
+)
+
+func NewProblem(err error, instance string, typeBase string) *Problem {
+	status := ErrorStatus(err)
+	p := &Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: instance,
+	}
+
+	var he *HTTPError
+	if errors.As(err, &he) {
+		p.Code = he.Code
+		p.Details = he.Details
+		if typeBase != "" && he.Code != "" {
+			p.Type = typeBase + he.Code
+		}
+	}
+	return p
+}
+
+func (pr ProblemErrorRenderer) RenderError(w http.ResponseWriter, r *http.Request, instance string, err error) {
+	offers := []string{"text/plain", CONTENT_TYPE_PROBLEM_JSON, "application/json"}
+	if strings.Contains(r.Header.Get("Accept"), "json") {
+		// clients listing a JSON type get problems on ties, as with axios's
+		// "application/json, text/plain, */*"
+		offers = []string{CONTENT_TYPE_PROBLEM_JSON, "application/json", "text/plain"}
+	}
+	if ct := negotiate(r.Header.Get("Accept"), offers); ct == "" || ct == "text/plain" {
+		ReportError(w, err)
+		return
+	}
+
+	p := NewProblem(err, instance, pr.TypeBase)
+	body, e := json.Marshal(p)
+	if e != nil {
+		ReportError(w, err)
+		return
+	}
+
+	setErrorHeaders(w, err)
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", CONTENT_TYPE_PROBLEM_JSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+func (TextErrorRenderer) RenderError(w http.ResponseWriter, r *http.Request, instance string, err error) {
+	ReportError(w, err)
+}
@@ -0,0 +1,54 @@
+package urest
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+type (
+	// QueryResource resources have named GET queries, reached at
+	// /resource/<name>?params when no child has that name. A query is an
+	// ordinary read-only child, so it gets the usual caching headers.
+	QueryResource interface {
+		Query(string, *http.Request) Resource
+		AllowedQueries() []string
+	}
+
+	queryData struct {
+		f func(url.Values, *http.Request) (interface{}, error)
+	}
+)
+
+// AddQuery adds a GET query answered with the data f returns for the request
+// query string. The returned resource can be configured further, e.g. with
+// HashETag or CacheDuration.
+func (d *DefaultResourceImpl) AddQuery(name string, f func(url.Values, *http.Request) (interface{}, error)) *DefaultResourceImpl {
+	q := NewDefaultResourceImpl(d, name)
+	q.ContentType_ = d.ContentType_
+	q.Gzip = d.Gzip
+	q.SetDataDelegate(queryData{f})
+	d.Queries[name] = q
+	return q
+}
+
+func (d *DefaultResourceImpl) Query(name string, r *http.Request) Resource {
+	return d.Queries[name]
+}
+
+func (d *DefaultResourceImpl) AllowedQueries() []string {
+	r := make([]string, 0, len(d.Queries))
+	for q := range d.Queries {
+		r = append(r, q)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func (q queryData) Data(prefix string, r *http.Request) (interface{}, error) {
+	return q.f(r.URL.Query(), r)
+}
+
+func (q queryData) LiveData(prefix string, r *http.Request) (interface{}, error) {
+	return q.f(r.URL.Query(), r)
+}
@@ -0,0 +1,231 @@
+package urest
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	byteRange struct {
+		start, length int64
+	}
+
+	// rangeResponseWriter holds back the body of a GET so that a Range can be
+	// cut out of it once its length is known.
+	rangeResponseWriter struct {
+		http.ResponseWriter
+		r      *http.Request
+		status int
+		body   bytes.Buffer
+	}
+)
+
+func (w *rangeResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *rangeResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+// flush writes the held back response, or returns the 416 error for the
+// Handler to render.
+func (w *rangeResponseWriter) flush() error {
+	if w.status == 0 {
+		return nil
+	}
+	if w.status == http.StatusOK {
+		if served, err := w.serveRanges(); served || err != nil {
+			return err
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+	return nil
+}
+
+// serveRanges answers with 206 and reports whether it did, or returns a 416
+// error.
+func (w *rangeResponseWriter) serveRanges() (bool, error) {
+	h := w.Header()
+	size := int64(w.body.Len())
+	if cl := h.Get("Content-Length"); cl == "" || cl != strconv.FormatInt(size, 10) {
+		return false, nil
+	}
+	if h.Get("Content-Encoding") != "" {
+		// the ETag is shared by all codings, so If-Range could splice
+		// bytes of different representations
+		h.Del("Accept-Ranges")
+		return false, nil
+	}
+	h.Set("Accept-Ranges", "bytes")
+
+	r := rangeRequest(w)
+	if r == "" {
+		return false, nil
+	}
+
+	ranges, err := parseRange(r, size)
+	if err != nil {
+		h.Del("Content-Length")
+		he := WrapHTTPError(http.StatusRequestedRangeNotSatisfiable, "range_not_satisfiable", err)
+		he.Header = http.Header{"Content-Range": {fmt.Sprintf("bytes */%d", size)}}
+		return false, he
+	}
+	if len(ranges) == 0 || !sensibleRanges(ranges, size) {
+		return false, nil
+	}
+
+	body := w.body.Bytes()
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		h.Set("Content-Range", ra.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.ResponseWriter.WriteHeader(http.StatusPartialContent)
+		w.ResponseWriter.Write(body[ra.start : ra.start+ra.length])
+		return true, nil
+	}
+
+	b := bytes.Buffer{}
+	mw := multipart.NewWriter(&b)
+	for _, ra := range ranges {
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Range": {ra.contentRange(size)},
+			"Content-Type":  {h.Get("Content-Type")},
+		})
+		part.Write(body[ra.start : ra.start+ra.length])
+	}
+	mw.Close()
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	h.Set("Content-Length", strconv.Itoa(b.Len()))
+	w.ResponseWriter.WriteHeader(http.StatusPartialContent)
+	w.ResponseWriter.Write(b.Bytes())
+	return true, nil
+}
+
+// rangeRequest returns the Range header unless If-Range says the client's
+// copy is stale.
+func rangeRequest(w *rangeResponseWriter) string {
+	r := w.r
+	rh := r.Header.Get("Range")
+	ir := r.Header.Get("If-Range")
+	if rh == "" || ir == "" {
+		return rh
+	}
+
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		if et := w.Header().Get("ETag"); et != "" && parseETag(ir).strongMatch(parseETag(et)) {
+			return rh
+		}
+		return ""
+	}
+
+	if lm := w.Header().Get("Last-Modified"); lm != "" {
+		t, err1 := http.ParseTime(ir)
+		m, err2 := http.ParseTime(lm)
+		if err1 == nil && err2 == nil && t.Equal(m) {
+			return rh
+		}
+	}
+	return ""
+}
+
+// sensibleRanges rejects overlapping ranges and ranges adding up to more
+// than the whole body, as net/http.ServeContent does, so that a Range cannot
+// amplify the response.
+func sensibleRanges(ranges []byteRange, size int64) bool {
+	sorted := append([]byteRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	total := int64(0)
+	for i, ra := range sorted {
+		if i > 0 && ra.start < sorted[i-1].start+sorted[i-1].length {
+			return false
+		}
+		total += ra.length
+	}
+	return total <= size
+}
+
+func (ra byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", ra.start, ra.start+ra.length-1, size)
+}
+
+func parseRange(s string, size int64) ([]byteRange, error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		// unknown range units are ignored
+		return nil, nil
+	}
+
+	ranges := make([]byteRange, 0)
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid range '%v'", ra)
+		}
+		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+
+		var r byteRange
+		if start == "" {
+			// suffix range: the last N bytes
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid range '%v'", ra)
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{size - n, n}
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("Invalid range '%v'", ra)
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if end == "" {
+				r.length = size - i
+			} else {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || i > j {
+					return nil, fmt.Errorf("Invalid range '%v'", ra)
+				}
+				if j >= size {
+					j = size - 1
+				}
+				r.length = j - i + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if noOverlap && len(ranges) == 0 {
+		return nil, fmt.Errorf("Range not satisfiable")
+	}
+	return ranges, nil
+}
@@ -0,0 +1,109 @@
+package urest
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type (
+	Route struct {
+		Path       string   `json:"path"`
+		Parent     string   `json:"parent,omitempty"`
+		Params     []string `json:"params,omitempty"`
+		Methods    []string `json:"methods"`
+		Actions    []string `json:"actions,omitempty"`
+		Collection bool     `json:"collection,omitempty"`
+		Query      bool     `json:"query,omitempty"`
+		Summary    string   `json:"summary,omitempty"`
+		Resource   Resource `json:"-"`
+	}
+)
+
+// Routes enumerates the resources reachable from root: static children of
+// Describable resources and, for dynamic collections, a templated child.
+func Routes(root Resource, prefix string) []Route {
+	routes := make([]Route, 0)
+	walkTree(root, prefix, func(n treeNode) {
+		actions := append([]string{}, n.res.AllowedActions()...)
+		sort.Strings(actions)
+
+		routes = append(routes, Route{
+			Path:       n.path,
+			Parent:     n.parent,
+			Params:     n.params,
+			Methods:    EffectiveMethods(n.res, nil),
+			Actions:    actions,
+			Collection: n.res.IsCollection(),
+			Query:      n.query,
+			Summary:    describe(n.res).Summary,
+			Resource:   n.res,
+		})
+	})
+	return routes
+}
+
+// RoutesFlag registers a flag with which binaries can be asked to print their
+// resource tree; pass its value to DumpRoutes.
+func RoutesFlag(fs *flag.FlagSet, name string) *string {
+	return fs.String(name, "", "print the resource tree as text, json or dot and exit")
+}
+
+func DumpRoutes(w io.Writer, root Resource, prefix string, format string) error {
+	return WriteRoutes(w, Routes(root, prefix), format)
+}
+
+func WriteRoutes(w io.Writer, routes []Route, format string) error {
+	switch format {
+	case "text", "":
+		return writeRoutesText(w, routes)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(routes)
+	case "dot":
+		return writeRoutesDot(w, routes)
+	}
+	return fmt.Errorf("Unknown routes format '%v'", format)
+}
+
+func writeRoutesText(w io.Writer, routes []Route) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, r := range routes {
+		fmt.Fprintf(tw, "%v\t%v", r.Path, strings.Join(r.Methods, " "))
+		if len(r.Actions) > 0 {
+			fmt.Fprintf(tw, "\tactions: %v", strings.Join(r.Actions, " "))
+		}
+		if r.Query {
+			fmt.Fprint(tw, "\tquery")
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func writeRoutesDot(w io.Writer, routes []Route) error {
+	b := &strings.Builder{}
+	b.WriteString("digraph routes {\n\trankdir=LR;\n\tnode [shape=box, fontname=monospace];\n")
+	for _, r := range routes {
+		fmt.Fprintf(b, "\t%q [label=%q];\n", r.Path, r.Path+"\n"+strings.Join(r.Methods, " "))
+		if r.Parent != "" && r.Query {
+			fmt.Fprintf(b, "\t%q -> %q [style=dotted];\n", r.Parent, r.Path)
+		} else if r.Parent != "" {
+			fmt.Fprintf(b, "\t%q -> %q;\n", r.Parent, r.Path)
+		}
+		for _, a := range r.Actions {
+			id := strings.TrimSuffix(r.Path, "/") + "/" + a
+			fmt.Fprintf(b, "\t%q [label=%q, shape=ellipse];\n", id, a+"\nPOST")
+			fmt.Fprintf(b, "\t%q -> %q [style=dashed];\n", r.Path, id)
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,216 @@
+package urest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+type (
+	// JSONSchema is the subset of JSON Schema that urest validates request
+	// bodies against and generates for typed data.
+	JSONSchema struct {
+		Ref                  string                 `json:"$ref,omitempty"`
+		Type                 string                 `json:"type,omitempty"`
+		Format               string                 `json:"format,omitempty"`
+		Description          string                 `json:"description,omitempty"`
+		Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+		Required             []string               `json:"required,omitempty"`
+		AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+		Items                *JSONSchema            `json:"items,omitempty"`
+		Enum                 []interface{}          `json:"enum,omitempty"`
+		Minimum              *float64               `json:"minimum,omitempty"`
+		Maximum              *float64               `json:"maximum,omitempty"`
+		MinLength            *int                   `json:"minLength,omitempty"`
+		MaxLength            *int                   `json:"maxLength,omitempty"`
+		MinItems             *int                   `json:"minItems,omitempty"`
+		MaxItems             *int                   `json:"maxItems,omitempty"`
+		Pattern              string                 `json:"pattern,omitempty"`
+	}
+)
+
+func ParseJSONSchema(data []byte) (*JSONSchema, error) {
+	s := &JSONSchema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ValidateJSON checks a document decoded by encoding/json (numbers as
+// float64 or json.Number) against the schema.
+func (s *JSONSchema) ValidateJSON(doc interface{}) error {
+	return s.validateJSON(doc, false)
+}
+
+func (s *JSONSchema) validateJSON(doc interface{}, partial bool) error {
+	if err := s.check(); err != nil {
+		return invalidRules(err)
+	}
+
+	errs := make(ValidationErrors, 0)
+	s.validate(doc, "", partial, &errs)
+	if len(errs) > 0 {
+		return validationFailed(errs)
+	}
+	return nil
+}
+
+// check compiles the patterns of the schema and its subschemas.
+func (s *JSONSchema) check() error {
+	if s.Pattern != "" {
+		if _, err := compilePattern(s.Pattern); err != nil {
+			return err
+		}
+	}
+	subs := []*JSONSchema{s.AdditionalProperties, s.Items}
+	for _, ps := range s.Properties {
+		subs = append(subs, ps)
+	}
+	for _, sub := range subs {
+		if sub != nil {
+			if err := sub.check(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *JSONSchema) validate(doc interface{}, path string, partial bool, errs *ValidationErrors) {
+	fail := func(rule string, format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{path, rule, fmt.Sprintf(format, args...)})
+	}
+
+	if doc == nil {
+		if s.Type != "" && s.Type != "null" {
+			fail("type", "must be %v", s.Type)
+		}
+		return
+	}
+
+	if s.Type != "" && !jsonTypeIs(doc, s.Type) {
+		fail("type", "must be %v", s.Type)
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if jsonEqual(normalizeNumbers(e), normalizeNumbers(doc)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "must be one of %v", s.Enum)
+		}
+	}
+
+	switch t := doc.(type) {
+	case map[string]interface{}:
+		if !partial {
+			for _, r := range s.Required {
+				if _, ok := t[r]; !ok {
+					*errs = append(*errs, FieldError{joinPath(path, r), "required", "is required"})
+				}
+			}
+		}
+
+		keys := sortedKeys(t)
+		for _, k := range keys {
+			if ps := s.Properties[k]; ps != nil {
+				ps.validate(t[k], joinPath(path, k), partial, errs)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(t[k], joinPath(path, k), partial, errs)
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(t) < *s.MinItems {
+			fail("minItems", "must have at least %v items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(t) > *s.MaxItems {
+			fail("maxItems", "must have at most %v items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, e := range t {
+				s.Items.validate(e, fmt.Sprintf("%v[%d]", path, i), partial, errs)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(t)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("minLength", "length must be at least %v", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("maxLength", "length must be at most %v", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			// checked by validateJSON
+			if re, err := compilePattern(s.Pattern); err == nil && !re.MatchString(t) {
+				fail("pattern", "must match %v", s.Pattern)
+			}
+		}
+	default:
+		if f, ok := jsonNumber(doc); ok {
+			if s.Minimum != nil && f < *s.Minimum {
+				fail("minimum", "must be at least %v", *s.Minimum)
+			}
+			if s.Maximum != nil && f > *s.Maximum {
+				fail("maximum", "must be at most %v", *s.Maximum)
+			}
+		}
+	}
+}
+
+func jsonTypeIs(doc interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := doc.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := doc.([]interface{})
+		return ok
+	case "string":
+		_, ok := doc.(string)
+		return ok
+	case "boolean":
+		_, ok := doc.(bool)
+		return ok
+	case "number":
+		_, ok := jsonNumber(doc)
+		return ok
+	case "integer":
+		f, ok := jsonNumber(doc)
+		return ok && f == math.Trunc(f)
+	case "null":
+		return doc == nil
+	}
+	return true
+}
+
+func jsonNumber(doc interface{}) (float64, bool) {
+	switch t := doc.(type) {
+	case float64:
+		return t, true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func normalizeNumbers(v interface{}) interface{} {
+	if f, ok := jsonNumber(v); ok {
+		return json.Number(fmt.Sprint(f))
+	}
+	return v
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
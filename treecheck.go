@@ -0,0 +1,181 @@
+package urest
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+type (
+	TreeError struct {
+		Path    string
+		Problem string
+	}
+
+	TreeErrors []TreeError
+)
+
+func (errs TreeErrors) Error() string {
+	parts := make([]string, 0, len(errs))
+	for _, e := range errs {
+		parts = append(parts, fmt.Sprintf("'%v': %v", e.Path, e.Problem))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ValidateTree walks the static part of the tree under root (see Describable)
+// and reports every structural problem it finds, so that they surface at
+// startup rather than as failing requests.
+func ValidateTree(root Resource) error {
+	errs := make(TreeErrors, 0)
+	fail := func(path string, format string, args ...interface{}) {
+		errs = append(errs, TreeError{path, fmt.Sprintf(format, args...)})
+	}
+
+	if root.Parent() != nil {
+		fail("/", "root resource has a parent")
+	}
+
+	r, _ := http.NewRequest("GET", "/", nil)
+	onPath := map[Resource]bool{}
+
+	var check func(res Resource, path string)
+	check = func(res Resource, path string) {
+		if reflect.TypeOf(res).Comparable() {
+			if onPath[res] {
+				fail(path, "resource is its own ancestor")
+				return
+			}
+			onPath[res] = true
+			defer delete(onPath, res)
+		}
+
+		checkMethods(res, path, fail)
+		if d := defaultImpl(res); d != nil && d.Schema != nil {
+			if err := d.Schema.check(); err != nil {
+				fail(path, "invalid schema: %v", err)
+			}
+		}
+
+		base := strings.TrimSuffix(path, "/") + "/"
+		desc := describe(res)
+		segments := map[string]string{}
+		for _, name := range desc.Children {
+			ch := res.Child(name, r)
+			if ch == nil {
+				fail(base+name, "Child('%v') returns nil", name)
+				continue
+			}
+
+			seg := ch.PathSegment()
+			switch {
+			case seg == "":
+				fail(base+name, "empty path segment")
+			case strings.Contains(seg, "/"):
+				fail(base+name, "path segment '%v' contains a slash", seg)
+			case seg != name:
+				fail(base+name, "path segment '%v' does not match child name '%v'", seg, name)
+			}
+			if other, ok := segments[seg]; ok {
+				fail(base+name, "duplicate path segment '%v' (also child '%v')", seg, other)
+			}
+			segments[seg] = name
+
+			if !linksBack(ch, res) {
+				fail(base+name, "Parent() does not link back to '%v'", path)
+			}
+			if index(res.AllowedActions(), seg) != -1 {
+				fail(base+name, "action '%v' collides with a child", seg)
+			}
+
+			check(ch, childPath(base, name, ch))
+		}
+
+		if qr, ok := res.(QueryResource); ok {
+			for _, name := range qr.AllowedQueries() {
+				q := qr.Query(name, r)
+				switch {
+				case q == nil:
+					fail(base+name, "Query('%v') returns nil", name)
+					continue
+				case q.PathSegment() != name:
+					fail(base+name, "path segment '%v' does not match query name '%v'", q.PathSegment(), name)
+				case !linksBack(q, res):
+					fail(base+name, "Parent() does not link back to '%v'", path)
+				}
+				if _, ok := segments[name]; ok || res.Child(name, r) != nil {
+					fail(base+name, "query '%v' is shadowed by a child", name)
+				}
+				if index(res.AllowedActions(), name) != -1 {
+					fail(base+name, "action '%v' collides with a query", name)
+				}
+				check(q, base+name)
+			}
+		}
+
+		if desc.ChildParam != "" && desc.ChildExample != nil {
+			p := childPath(base, "{"+desc.ChildParam+"}", desc.ChildExample)
+			if !linksBack(desc.ChildExample, res) {
+				fail(p, "Parent() does not link back to '%v'", path)
+			}
+			check(desc.ChildExample, p)
+		}
+	}
+	check(root, "/")
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// linksBack reports whether the parent of ch is res. Resources of types that
+// cannot be compared are given the benefit of the doubt.
+func linksBack(ch Resource, res Resource) bool {
+	p := ch.Parent()
+	if p == nil || reflect.TypeOf(p) != reflect.TypeOf(res) {
+		return p == res
+	}
+	return !reflect.TypeOf(res).Comparable() || p == res
+}
+
+// checkMethods flags allowed methods a plain DefaultResourceImpl has no
+// delegate for. Types embedding it may implement the methods themselves.
+func checkMethods(res Resource, path string, fail func(string, string, ...interface{})) {
+	d, ok := res.(*DefaultResourceImpl)
+	if !ok {
+		return
+	}
+
+	_, canUpdate := d.write.(UpdateResource)
+	for _, m := range d.AllowedMethods() {
+		missing := false
+		switch m {
+		case "GET":
+			missing = d.readRawFunc == nil && d.list == nil
+		case "PUT":
+			missing = d.write == nil
+		case "PATCH":
+			missing = !canUpdate && (d.write == nil || d.data == nil)
+		case "POST":
+			if d.IsCollection_ {
+				missing = d.collection == nil && len(d.AllowedActions()) == 0
+			} else if len(d.AllowedActions()) == 0 {
+				// Replace is reached by POST only in legacy mode, which
+				// allows POST wherever PUT is
+				fail(path, "POST is allowed but there are no actions (see Handler.SetLegacyPostReplace)")
+			}
+		case "DELETE":
+			if p, ok := d.Parent_.(*DefaultResourceImpl); ok {
+				_, canDelete := p.collection.(DeleteResource)
+				missing = !canDelete
+			} else if d.Parent_ == nil || !d.Parent_.IsCollection() {
+				fail(path, "DELETE is allowed but the parent is not a collection")
+			}
+		}
+		if missing {
+			fail(path, "%v is allowed but has no implementation", m)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package urest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+type (
+	TypedSource[T any] interface {
+		Get(ctx context.Context) (T, error)
+	}
+
+	TypedPutter[T any] interface {
+		Put(ctx context.Context, v T) error
+	}
+
+	TypedPatcher[T any] interface {
+		Patch(ctx context.Context, v T) error
+	}
+
+	TypedStore[ID comparable, T any] interface {
+		Get(ctx context.Context, id ID) (T, error)
+		List(ctx context.Context) ([]T, error)
+	}
+
+	TypedItemPutter[ID comparable, T any] interface {
+		Put(ctx context.Context, id ID, v T) error
+	}
+
+	TypedItemPatcher[ID comparable, T any] interface {
+		Patch(ctx context.Context, id ID, v T) error
+	}
+
+	TypedCreator[ID comparable, T any] interface {
+		Create(ctx context.Context, v T) (ID, error)
+	}
+
+	TypedDeleter[ID comparable] interface {
+		Delete(ctx context.Context, id ID) error
+	}
+
+	TypedResource[T any] struct {
+		*DefaultResourceImpl
+	}
+
+	// TypedCollection serves a TypedStore: the collection lists it, children
+	// are items addressed by ID. ParseID and FormatID default to strconv for
+	// string and integer IDs; ConfigureItem may adjust each item resource.
+	TypedCollection[ID comparable, T any] struct {
+		*DefaultResourceImpl
+		ParseID       func(string) (ID, error)
+		FormatID      func(ID) string
+		ConfigureItem func(*DefaultResourceImpl)
+		store         TypedStore[ID, T]
+	}
+
+	typedData[T any] struct {
+		get func(context.Context) (T, error)
+	}
+
+	typedWrite[T any] struct {
+		put func(context.Context, T) error
+	}
+
+	typedPatchWrite[T any] struct {
+		typedWrite[T]
+		patch func(context.Context, T) error
+	}
+
+	typedCreate[ID comparable, T any] struct {
+		coll *TypedCollection[ID, T]
+	}
+)
+
+func NewTypedResource[T any](parent Resource, pathSegment string, src TypedSource[T]) *TypedResource[T] {
+	d := NewDefaultResourceImpl(parent, pathSegment)
+	d.SetDataDelegate(typedData[T]{src.Get})
+
+	if p, ok := src.(TypedPutter[T]); ok {
+		d.SetWriteDelegate(newTypedWrite[T](p.Put, src))
+	}
+
+	return &TypedResource[T]{d}
+}
+
+func NewTypedCollection[ID comparable, T any](parent Resource, pathSegment string, store TypedStore[ID, T]) *TypedCollection[ID, T] {
+	c := &TypedCollection[ID, T]{
+		DefaultResourceImpl: NewDefaultResourceImpl(parent, pathSegment),
+		ParseID:             parseID[ID],
+		FormatID:            formatID[ID],
+		store:               store,
+	}
+	c.IsCollection_ = true
+	c.SetDataDelegate(typedData[[]T]{store.List})
+	c.SetListDelegate(typedPager[T]{store.List})
+
+	if _, ok := store.(TypedCreator[ID, T]); ok {
+		c.SetCollectionDelegate(typedCreate[ID, T]{c})
+	}
+	if _, ok := store.(TypedItemPutter[ID, T]); ok {
+		// once here, as Item runs for every request
+		if err := checkRules(new(T)); err != nil {
+			panic(fmt.Sprintf("Item type has invalid validation rules: %v", err))
+		}
+	}
+
+	return c
+}
+
+func (c *TypedCollection[ID, T]) Child(name string, r *http.Request) Resource {
+	if ch := c.DefaultResourceImpl.Child(name, r); ch != nil {
+		return ch
+	}
+	if _, ok := c.Queries[name]; ok {
+		// any name may parse as an ID; leave query names to navigation
+		return nil
+	}
+
+	id, err := c.ParseID(name)
+	if err != nil || c.FormatID(id) != name {
+		return nil
+	}
+
+	// a PUT to a missing item goes to CreateAt, which answers 201
+	if _, ok := c.store.(TypedItemPutter[ID, T]); ok && r != nil && r.Method == "PUT" {
+		if _, err := c.store.Get(r.Context(), id); err != nil && ErrorStatus(err) == http.StatusNotFound {
+			return nil
+		}
+	}
+	return c.Item(id)
+}
+
+// AddQuery adds a query as DefaultResourceImpl.AddQuery does, with c as its
+// parent.
+func (c *TypedCollection[ID, T]) AddQuery(name string, f func(url.Values, *http.Request) (interface{}, error)) *DefaultResourceImpl {
+	q := c.DefaultResourceImpl.AddQuery(name, f)
+	q.Parent_ = c
+	return q
+}
+
+// CreateAt stores the item PUT to a missing ID. The store's Get must report
+// missing items with a 404 error (such as ErrNotFound) for PUT to get here.
+func (c *TypedCollection[ID, T]) CreateAt(name string, r *http.Request) (Resource, error) {
+	id, err := c.ParseID(name)
+	if err != nil || c.FormatID(id) != name {
+		return nil, ErrNotFound
+	}
+
+	item := c.Item(id)
+	if index(item.AllowedMethods(), "PUT") == -1 {
+		return nil, NewHTTPError(http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	}
+	if err := item.Replace(r); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Item returns the resource of the item with the given ID. It does not check
+// that the item exists: reading a missing item is up to the store's Get.
+func (c *TypedCollection[ID, T]) Item(id ID) *DefaultResourceImpl {
+	d := NewDefaultResourceImpl(c, c.FormatID(id))
+	d.Schema = c.Schema
+	d.SetDataDelegate(typedData[T]{func(ctx context.Context) (T, error) {
+		return c.store.Get(ctx, id)
+	}})
+
+	if p, ok := c.store.(TypedItemPutter[ID, T]); ok {
+		put := func(ctx context.Context, v T) error { return p.Put(ctx, id, v) }
+		if pp, ok := c.store.(TypedItemPatcher[ID, T]); ok {
+			d.setWriteDelegate(typedPatchWrite[T]{typedWrite[T]{put}, func(ctx context.Context, v T) error {
+				return pp.Patch(ctx, id, v)
+			}})
+		} else {
+			d.setWriteDelegate(typedWrite[T]{put})
+		}
+	}
+
+	if _, ok := c.store.(TypedDeleter[ID]); ok {
+		d.addMethods("DELETE")
+	}
+
+	if c.ConfigureItem != nil {
+		c.ConfigureItem(d)
+	}
+	return d
+}
+
+func (c *TypedCollection[ID, T]) Delete(name string, r *http.Request) error {
+	del, ok := c.store.(TypedDeleter[ID])
+	if !ok {
+		return c.DefaultResourceImpl.Delete(name, r)
+	}
+
+	id, err := c.ParseID(name)
+	if err != nil {
+		return ErrNotFound
+	}
+	return del.Delete(r.Context(), id)
+}
+
+func newTypedWrite[T any](put func(context.Context, T) error, src interface{}) WriteResource {
+	if p, ok := src.(TypedPatcher[T]); ok {
+		return typedPatchWrite[T]{typedWrite[T]{put}, p.Patch}
+	}
+	return typedWrite[T]{put}
+}
+
+func (t typedData[T]) Data(_ string, r *http.Request) (interface{}, error) {
+	return t.get(r.Context())
+}
+
+func (t typedData[T]) LiveData(_ string, r *http.Request) (interface{}, error) {
+	return t.get(r.Context())
+}
+
+func (typedData[T]) DataType() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (typedWrite[T]) NewValue() interface{} {
+	return new(T)
+}
+
+func (t typedWrite[T]) Replace(v interface{}, r *http.Request) error {
+	return t.put(r.Context(), *v.(*T))
+}
+
+func (t typedPatchWrite[T]) Update(v interface{}, r *http.Request) error {
+	return t.patch(r.Context(), *v.(*T))
+}
+
+func (typedCreate[ID, T]) NewValue() interface{} {
+	return new(T)
+}
+
+func (t typedCreate[ID, T]) Create(v interface{}, r *http.Request) (Resource, error) {
+	id, err := t.coll.store.(TypedCreator[ID, T]).Create(r.Context(), *v.(*T))
+	if err != nil {
+		return nil, err
+	}
+	return t.coll.Item(id), nil
+}
+
+func parseID[ID comparable](s string) (ID, error) {
+	var id ID
+	var err error
+
+	switch p := any(&id).(type) {
+	case *string:
+		*p = s
+	case *int:
+		*p, err = strconv.Atoi(s)
+	case *int64:
+		*p, err = strconv.ParseInt(s, 10, 64)
+	case *int32:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 32)
+		*p = int32(i)
+	case *uint:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, 0)
+		*p = uint(u)
+	case *uint64:
+		*p, err = strconv.ParseUint(s, 10, 64)
+	case *uint32:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, 32)
+		*p = uint32(u)
+	default:
+		err = fmt.Errorf("No default ID parser for %T, set ParseID", id)
+	}
+	return id, err
+}
+
+func formatID[ID comparable](id ID) string {
+	return fmt.Sprint(id)
+}
@@ -1,6 +1,7 @@
 package urest
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -38,54 +39,225 @@ type (
 		Delete(string, *http.Request) error
 	}
 
+	// PutCollection creates children on PUT to a name it does not have yet.
+	// Implementing it is enough: the collection itself need not allow PUT.
+	PutCollection interface {
+		Collection
+
+		CreateAt(string, *http.Request) (Resource, error)
+	}
+
+	// RedirectPolicy decides what happens to requests whose path differs from
+	// the canonical URL of the resource, e.g. by a trailing slash.
+	RedirectPolicy int
+
 	Handler struct {
-		res    Resource
-		prefix string
+		res               Resource
+		prefix            string
+		errors            ErrorRenderer
+		legacyPostReplace bool
+		notFound          http.Handler
+		redirect          RedirectPolicy
+		mounts            map[string]Resource
+		jobs              *jobsResource
+	}
+
+	prefixKey struct{}
+
+	// headResponseWriter drops the body of a GET answering HEAD.
+	headResponseWriter struct {
+		http.ResponseWriter
 	}
 )
 
+const (
+	REDIRECT_MOVED_PERMANENTLY RedirectPolicy = iota // 301, the default
+	REDIRECT_PERMANENT                               // 308, keeps method and body
+	REDIRECT_NONE                                    // serve the resource as is
+	REDIRECT_NOT_FOUND                               // 404
+)
+
 func NewHandler(res Resource, prefix string) *Handler {
 	if !strings.HasPrefix(prefix, "/") {
 		log.Panicf("Invalid prefix '%v'", prefix)
 	}
+	if err := ValidateTree(res); err != nil {
+		log.Panicf("Invalid resource tree: %v", err)
+	}
+
+	return &Handler{res, prefix, ProblemErrorRenderer{}, false, nil, REDIRECT_MOVED_PERMANENTLY, map[string]Resource{}, nil}
+}
+
+// SetNotFound sets the handler for requests that match no resource, in place
+// of the error renderer's 404.
+func (h *Handler) SetNotFound(nf http.Handler) {
+	h.notFound = nf
+}
 
-	return &Handler{res, prefix}
+// SetLegacyPostReplace makes POST to a non-collection resource call Replace,
+// as it did before PUT was supported. POST is then allowed wherever PUT is;
+// it must not be listed in the allowed methods of such resources.
+func (h *Handler) SetLegacyPostReplace(legacy bool) {
+	h.legacyPostReplace = legacy
+}
+
+func (h *Handler) SetRedirectPolicy(p RedirectPolicy) {
+	h.redirect = p
+}
+
+func (h *Handler) SetErrorRenderer(er ErrorRenderer) {
+	h.errors = er
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if h.res.Parent() != nil {
-		log.Panicf("Resource '%v' is not a root of the resource tree", relativeURL(h.res))
+	r = WithRequestData(r.WithContext(context.WithValue(r.Context(), prefixKey{}, h.prefix)))
+	ch, postAction, err := h.Resolve(r)
+
+	if err != nil {
+		if ErrorStatus(err) == http.StatusNotFound && h.notFound != nil {
+			h.notFound.ServeHTTP(w, r)
+			return
+		}
+		if ErrorStatus(err) >= http.StatusInternalServerError {
+			log.Printf("Navigation failed: %v", err)
+		}
+		h.errors.RenderError(w, r, r.URL.Path, err)
+		return
 	}
 
-	if r.URL.Path[:len(h.prefix)] != h.prefix {
-		log.Panicf("Prefix '%v' does not match request URL path '%v'", h.prefix, r.URL.Path)
+	if postAction != nil && r.Method == "PUT" {
+		h.putNew(ch, *postAction, w, r)
+		return
+	}
+	if postAction != nil && r.Method != "POST" && r.Method != "OPTIONS" {
+		h.reportNotFound(w, r, ch)
+		return
+	}
+	if path, _ := h.requestPath(r); postAction == nil && RelativeURL(h.prefix, ch).EscapedPath() != canonicalPath(path) {
+		if h.redirectCanonical(ch, w, r) {
+			return
+		}
 	}
 
-	steps := strings.Split(r.URL.Path[len(h.prefix):], "/")
-	ch, rest, err := navigate(h.res, steps, r)
+	h.handle(ch, postAction, w, r)
+}
 
+// Resolve finds the resource r targets and the trailing path segment that
+// matched no child of it (an action name), if any.
+func (h *Handler) Resolve(r *http.Request) (Resource, *string, error) {
+	path, ok := h.requestPath(r)
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+
+	steps := strings.Split(strings.TrimPrefix(path, strings.TrimSuffix(h.prefix, "/")), "/")
+	root, steps := h.mounted(steps)
+	ch, rest, err := navigate(root, steps, r)
 	if err != nil {
-		log.Printf("Navigation failed: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, nil, err
 	}
 
-	postAction := (*string)(nil)
 	if len(rest) > 0 {
-		postAction = &rest[0]
+		return ch, &rest[0], nil
+	}
+	return ch, nil, nil
+}
+
+// mounted picks the root-level resource the Handler itself provides (such as
+// the job resources) that steps lead into, if any.
+func (h *Handler) mounted(steps []string) (Resource, []string) {
+	for i, s := range steps {
+		if s == "" {
+			continue
+		}
+		if name, err := url.PathUnescape(s); err == nil && h.mounts[name] != nil {
+			return h.mounts[name], steps[i+1:]
+		}
+		break
 	}
+	return h.res, steps
+}
 
-	if postAction != nil && r.Method != "POST" {
-		http.NotFound(w, r)
-		return
+// requestPath returns the escaped request path including the handler prefix.
+// When the handler is mounted under http.StripPrefix, r.URL lacks the prefix
+// and the original path is taken from r.RequestURI instead.
+func (h *Handler) requestPath(r *http.Request) (string, bool) {
+	path := r.URL.EscapedPath()
+	if hasPathPrefix(path, h.prefix) {
+		return path, true
 	}
-	if postAction == nil && RelativeURL(h.prefix, ch).Path != r.URL.Path {
-		w.Header().Set("Location", RelativeURL(h.prefix, ch).Path)
-		w.WriteHeader(http.StatusMovedPermanently)
-		return
+
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		if orig := u.EscapedPath(); orig != path && strings.HasSuffix(orig, path) && hasPathPrefix(orig, h.prefix) {
+			return orig, true
+		}
+	}
+
+	return "", false
+}
+
+// canonicalPath re-escapes every segment of an escaped path the way
+// RelativeURL does, so that "/a%3a" and "/a:" compare equal.
+func canonicalPath(escaped string) string {
+	steps := strings.Split(escaped, "/")
+	for i, s := range steps {
+		if u, err := url.PathUnescape(s); err == nil {
+			steps[i] = url.PathEscape(u)
+		}
 	}
+	return strings.Join(steps, "/")
+}
 
-	handle(ch, postAction, h.prefix, w, r)
+// hasPathPrefix reports whether path is prefix or lies below it; "/api"
+// matches "/api/" and "/api/x" but not "/apix".
+func hasPathPrefix(path string, prefix string) bool {
+	base := strings.TrimSuffix(prefix, "/")
+	if path == base {
+		return true
+	}
+	return strings.HasPrefix(path, base+"/")
+}
+
+// redirectCanonical applies the redirect policy to a request for res made
+// through a non-canonical path and reports whether the response is done.
+func (h *Handler) redirectCanonical(res Resource, w http.ResponseWriter, r *http.Request) bool {
+	status := http.StatusMovedPermanently
+	switch h.redirect {
+	case REDIRECT_NONE:
+		return false
+	case REDIRECT_NOT_FOUND:
+		h.reportNotFound(w, r, res)
+		return true
+	case REDIRECT_PERMANENT:
+		status = http.StatusPermanentRedirect
+	}
+
+	loc := RelativeURL(h.prefix, res)
+	loc.RawQuery = r.URL.RawQuery
+	w.Header().Set("Location", loc.String())
+	w.WriteHeader(status)
+	return true
+}
+
+func (h *Handler) reportNotFound(w http.ResponseWriter, r *http.Request, res Resource) {
+	if h.notFound != nil {
+		h.notFound.ServeHTTP(w, r)
+	} else {
+		h.reportError(w, r, res, ErrNotFound)
+	}
+}
+
+// requestPrefix is the URL prefix of the Handler serving r, for resource
+// methods that are not passed it.
+func requestPrefix(r *http.Request) string {
+	if p, ok := r.Context().Value(prefixKey{}).(string); ok {
+		return p
+	}
+	return ""
+}
+
+func (h *Handler) reportError(w http.ResponseWriter, r *http.Request, res Resource, err error) {
+	h.errors.RenderError(w, r, RelativeURL(h.prefix, res).String(), err)
 }
 
 func navigate(res Resource, steps []string, r *http.Request) (Resource, []string, error) {
@@ -93,103 +265,162 @@ func navigate(res Resource, steps []string, r *http.Request) (Resource, []string
 		return res, []string{}, nil
 	}
 
-	head := steps[0]
 	rest := steps[1:]
-
-	if head == "" {
+	if steps[0] == "" {
 		return navigate(res, rest, r)
 	}
 
-	if ch := res.Child(head, r); ch != nil {
+	head, err := url.PathUnescape(steps[0])
+	if err != nil {
+		return nil, nil, WrapHTTPError(http.StatusBadRequest, "invalid_path", err)
+	}
+
+	ch := res.Child(head, r)
+	if qr, ok := res.(QueryResource); ok && ch == nil {
+		ch = qr.Query(head, r)
+	}
+
+	if ch != nil {
 		if ch.PathSegment() != head {
-			return nil, nil, fmt.Errorf("Resource '%v' has wrong path segment ('%v' / '%v')", relativeURL(ch), ch.PathSegment(), head)
+			return nil, nil, WrapHTTPError(http.StatusInternalServerError, "wrong_path_segment",
+				fmt.Errorf("Resource '%v' has wrong path segment ('%v' / '%v')", relativeURL(ch), ch.PathSegment(), head))
 		}
 		return navigate(ch, rest, r)
 	} else {
 		if len(rest) != 0 {
-			return nil, nil, fmt.Errorf("Non-empty descendants of non-child node ('%v' / '%v')", head, rest)
+			return nil, nil, ErrNotFound
 		}
 		return res, []string{head}, nil
 	}
 }
 
-func handle(res Resource, postAction *string, prefix string, w http.ResponseWriter, r *http.Request) {
-	if index(res.AllowedMethods(), r.Method) == -1 {
-		w.Header().Set("Allow", strings.Join(res.AllowedMethods(), ", "))
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func (h *Handler) handle(res Resource, postAction *string, w http.ResponseWriter, r *http.Request) {
+	if postAction != nil && index(res.AllowedActions(), *postAction) == -1 {
+		h.reportError(w, r, res, NewHTTPError(http.StatusBadRequest, "unknown_action", "Unknown action"))
+		return
+	}
+
+	if index(h.EffectiveMethods(res, postAction), r.Method) == -1 {
+		w.Header().Set("Allow", strings.Join(h.EffectiveMethods(res, postAction), ", "))
+		h.reportError(w, r, res, NewHTTPError(http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed"))
 		return
 	}
 
+	if r.Method != "OPTIONS" {
+		switch checkPreconditions(res, r) {
+		case http.StatusNotModified:
+			setHeaders(res, w, r)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case http.StatusPreconditionFailed:
+			h.reportError(w, r, res, NewHTTPError(http.StatusPreconditionFailed, "precondition_failed", "Precondition failed"))
+			return
+		}
+	}
+
 	switch r.Method {
+	case "OPTIONS":
+		h.options(res, postAction, w, r)
 	case "HEAD":
 		setHeaders(res, w, r)
-		w.Header().Set("Allow", strings.Join(res.AllowedMethods(), ", "))
-		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Allow", strings.Join(h.EffectiveMethods(res, nil), ", "))
+		if index(res.AllowedMethods(), "GET") == -1 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// read as for GET, so that HEAD gets the same validators and 304s
+		if e := h.read(res, headResponseWriter{w}, r); e != nil {
+			h.reportError(w, r, res, e)
+		}
 	case "GET":
 		setHeaders(res, w, r)
-		if et := etag(res, r); et != "" {
-			if r.Header.Get("If-None-Match") == et {
-				w.WriteHeader(http.StatusNotModified)
-				return
-			}
+		rw := http.ResponseWriter(w)
+		rrw := (*rangeResponseWriter)(nil)
+		if r.Header.Get("Range") != "" {
+			rrw = &rangeResponseWriter{ResponseWriter: w, r: r}
+			rw = rrw
 		}
 
-		if e := res.Read(prefix, w, r); e != nil {
-			ReportError(w, e)
+		if e := h.read(res, rw, r); e != nil {
+			h.reportError(w, r, res, e)
+		} else if rrw != nil {
+			if e := rrw.flush(); e != nil {
+				h.reportError(w, r, res, e)
+			}
 		}
 	case "POST":
 		if postAction != nil {
-			if index(res.AllowedActions(), *postAction) == -1 {
-				http.Error(w, "Unknown action", http.StatusBadRequest)
-				return
-			}
-
-			if e := res.Do(*postAction, r); e != nil {
-				ReportError(w, e)
-			} else {
-				w.WriteHeader(http.StatusNoContent)
-			}
+			h.doAction(res, *postAction, w, r)
 		} else {
 			if res.IsCollection() {
 				if ch, e := res.(Collection).Create(r); e != nil {
-					ReportError(w, e)
+					h.reportError(w, r, res, e)
 				} else {
-					w.Header().Set("Location", RelativeURL(prefix, ch).String())
+					w.Header().Set("Location", RelativeURL(h.prefix, ch).String())
 					w.WriteHeader(http.StatusCreated)
 				}
-			} else {
+			} else if h.legacyPostReplace {
 				if e := res.Replace(r); e != nil {
-					ReportError(w, e)
+					h.reportError(w, r, res, e)
 				} else {
 					w.WriteHeader(http.StatusNoContent)
 				}
+			} else {
+				w.Header().Set("Allow", strings.Join(h.EffectiveMethods(res, nil), ", "))
+				h.reportError(w, r, res, NewHTTPError(http.StatusMethodNotAllowed, "method_not_allowed", "POST to a non-collection resource"))
 			}
 		}
+	case "PUT":
+		if e := res.Replace(r); e != nil {
+			h.reportError(w, r, res, e)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
 	case "PATCH":
 		if e := res.Update(r); e != nil {
-			ReportError(w, e)
+			h.reportError(w, r, res, e)
 		} else {
 			w.WriteHeader(http.StatusNoContent)
 		}
 	case "DELETE":
 		if res.Parent() == nil {
-			http.Error(w, "No parent", http.StatusBadRequest)
+			h.reportError(w, r, res, NewHTTPError(http.StatusBadRequest, "no_parent", "No parent"))
 			return
 		}
 
 		if !res.Parent().IsCollection() {
-			http.Error(w, "Parent is not a collection", http.StatusBadRequest)
+			h.reportError(w, r, res, NewHTTPError(http.StatusBadRequest, "parent_not_collection", "Parent is not a collection"))
 			return
 		}
 
 		if e := res.Parent().(Collection).Delete(res.PathSegment(), r); e != nil {
-			ReportError(w, e)
+			h.reportError(w, r, res, e)
 		} else {
 			w.WriteHeader(http.StatusNoContent)
 		}
 	}
 }
 
+func (h *Handler) putNew(coll Resource, name string, w http.ResponseWriter, r *http.Request) {
+	pc, ok := coll.(PutCollection)
+	if !ok || !coll.IsCollection() {
+		h.reportNotFound(w, r, coll)
+		return
+	}
+
+	if r.Header.Get("If-Match") != "" {
+		h.reportError(w, r, coll, NewHTTPError(http.StatusPreconditionFailed, "precondition_failed", "Precondition failed"))
+		return
+	}
+
+	if ch, e := pc.CreateAt(name, r); e != nil {
+		h.reportError(w, r, coll, e)
+	} else {
+		w.Header().Set("Location", RelativeURL(h.prefix, ch).String())
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
 func setHeaders(res Resource, w http.ResponseWriter, r *http.Request) {
 	if ct := res.ContentType(); ct != "" {
 		w.Header().Set("Content-Type", ct)
@@ -205,6 +436,20 @@ func setHeaders(res Resource, w http.ResponseWriter, r *http.Request) {
 	if et := etag(res, r); et != "" {
 		w.Header().Set("ETag", et)
 	}
+	if lm := lastModified(res, r); !lm.IsZero() {
+		w.Header().Set("Last-Modified", lm.Format(http.TimeFormat))
+	}
+}
+
+func (h *Handler) read(res Resource, w http.ResponseWriter, r *http.Request) error {
+	if pc, ok := res.(PaginatedCollection); ok && res.IsCollection() {
+		return h.list(res, pc, w, r)
+	}
+	return res.Read(h.prefix, w, r)
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
 }
 
 func etag(res Resource, r *http.Request) string {
@@ -240,6 +485,7 @@ func RelativeURL(prefix string, res Resource) *url.URL {
 		prefix = prefix[:len(prefix)-1]
 	}
 	u.Path = prefix + u.Path
+	u.RawPath = prefix + u.RawPath
 	return u
 }
 
@@ -257,12 +503,19 @@ func relativeURL(res Resource) *url.URL {
 		parts[i], parts[j] = parts[j], parts[i]
 	}
 
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = url.PathEscape(p)
+	}
+
 	path := strings.Join(parts, "/")
+	rawPath := strings.Join(escaped, "/")
 	if isColl {
 		path = path + "/"
+		rawPath = rawPath + "/"
 	}
 
-	return &url.URL{Path: path}
+	return &url.URL{Path: path, RawPath: rawPath}
 }
 
 func index(arr []string, s string) int {
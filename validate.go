@@ -0,0 +1,348 @@
+package urest
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	FieldError struct {
+		Field   string `json:"field"`
+		Rule    string `json:"rule"`
+		Message string `json:"message"`
+	}
+
+	ValidationErrors []FieldError
+
+	// Validatable values check themselves after struct tag rules passed.
+	Validatable interface {
+		Validate() error
+	}
+
+	rule struct {
+		name  string
+		arg   string
+		limit float64
+		re    *regexp.Regexp
+	}
+)
+
+var (
+	patterns sync.Map
+	rules    sync.Map
+)
+
+func (errs ValidationErrors) Error() string {
+	parts := make([]string, 0, len(errs))
+	for _, e := range errs {
+		if e.Field == "" {
+			parts = append(parts, e.Message)
+		} else {
+			parts = append(parts, e.Field+": "+e.Message)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func validationFailed(errs ValidationErrors) error {
+	return &HTTPError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    "validation_failed",
+		Message: "Validation failed",
+		Details: errs,
+		Err:     errs,
+	}
+}
+
+// Validate checks v against the rules in its `validate` struct tags:
+// required, min=N, max=N (value for numbers, length otherwise), enum=a|b|c
+// and pattern=RE, which must come last. Rules apply to zero values too,
+// except for nil fields and fields marked optional. Nested structs, pointers and slices
+// of structs are validated too.
+func Validate(v interface{}) error {
+	return validate(v, false)
+}
+
+// validate skips the required rule for partial values, such as PATCH bodies.
+// Malformed rules are a server error.
+func validate(v interface{}, partial bool) error {
+	errs := make(ValidationErrors, 0)
+	if err := validateValue(reflect.ValueOf(v), "", partial, &errs); err != nil {
+		return invalidRules(err)
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs)
+	}
+
+	if vv, ok := v.(Validatable); ok {
+		if err := vv.Validate(); err != nil {
+			if ve, ok := err.(ValidationErrors); ok {
+				return validationFailed(ve)
+			}
+			return &HTTPError{Status: http.StatusUnprocessableEntity, Code: "validation_failed", Message: "Validation failed", Err: err}
+		}
+	}
+	return nil
+}
+
+func invalidRules(err error) error {
+	return &HTTPError{Status: http.StatusInternalServerError, Code: "invalid_rules", Message: "Invalid validation rules", Err: err}
+}
+
+func validateValue(v reflect.Value, path string, partial bool, errs *ValidationErrors) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name := jsonFieldName(f)
+			if name == "-" {
+				continue
+			}
+			fp := name
+			if path != "" {
+				fp = path + "." + name
+			}
+
+			fv := v.Field(i)
+			if tag := f.Tag.Get("validate"); tag != "" {
+				if err := validateField(fv, fp, tag, partial, errs); err != nil {
+					return err
+				}
+			}
+			if err := validateValue(fv, fp, partial, errs); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%v[%d]", path, i), partial, errs); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if err := validateValue(v.MapIndex(k), fmt.Sprintf("%v[%v]", path, k), partial, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateField(v reflect.Value, path string, tag string, partial bool, errs *ValidationErrors) error {
+	fail := func(rule string, format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{path, rule, fmt.Sprintf(format, args...)})
+	}
+
+	parsed, err := parseRules(tag)
+	if err != nil {
+		return fmt.Errorf("Field '%v': %v", path, err)
+	}
+
+	// zero numbers and empty strings are checked like any other value, unless
+	// the field is optional or absent from a partial value
+	if v.IsZero() {
+		if !partial && strings.Contains(","+tag+",", ",required,") {
+			fail("required", "is required")
+			return nil
+		}
+		if partial || isNil(v) || strings.Contains(","+tag+",", ",optional,") {
+			return nil
+		}
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	for _, rule := range parsed {
+		switch rule.name {
+		case "min", "max":
+			n, isLen := measure(v)
+			what := "must be"
+			if isLen {
+				what = "length must be"
+			}
+			if rule.name == "min" && n < rule.limit {
+				fail(rule.name, "%v at least %v", what, rule.arg)
+			} else if rule.name == "max" && n > rule.limit {
+				fail(rule.name, "%v at most %v", what, rule.arg)
+			}
+		case "enum":
+			s := fmt.Sprint(v.Interface())
+			if index(strings.Split(rule.arg, "|"), s) == -1 {
+				fail(rule.name, "must be one of %v", strings.Replace(rule.arg, "|", ", ", -1))
+			}
+		case "pattern":
+			if !rule.re.MatchString(fmt.Sprint(v.Interface())) {
+				fail(rule.name, "must match %v", rule.arg)
+			}
+		}
+	}
+	return nil
+}
+
+// parseRules parses a validate tag once, checking limits, patterns and rule
+// names.
+func parseRules(tag string) ([]rule, error) {
+	if r, ok := rules.Load(tag); ok {
+		return r.([]rule), nil
+	}
+
+	parsed := make([]rule, 0)
+	for _, nv := range splitRules(tag) {
+		r := rule{name: nv[0], arg: nv[1]}
+		switch r.name {
+		case "", "required", "optional":
+			continue
+		case "min", "max":
+			limit, err := strconv.ParseFloat(r.arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %v rule '%v'", r.name, r.arg)
+			}
+			r.limit = limit
+		case "enum":
+		case "pattern":
+			re, err := compilePattern(r.arg)
+			if err != nil {
+				return nil, err
+			}
+			r.re = re
+		default:
+			return nil, fmt.Errorf("Unknown validation rule '%v'", r.name)
+		}
+		parsed = append(parsed, r)
+	}
+	rules.Store(tag, parsed)
+	return parsed, nil
+}
+
+// checkRules parses every validate tag reachable from the type of v, so that
+// malformed rules are found when a delegate is installed rather than by a
+// request.
+func checkRules(v interface{}) error {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil
+	}
+	return checkTypeRules(t, "", map[reflect.Type]bool{})
+}
+
+func checkTypeRules(t reflect.Type, path string, seen map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := jsonFieldName(f)
+		if name == "-" {
+			continue
+		}
+		fp := name
+		if path != "" {
+			fp = path + "." + name
+		}
+
+		if _, err := parseRules(f.Tag.Get("validate")); err != nil {
+			return fmt.Errorf("Field '%v': %v", fp, err)
+		}
+		if err := checkTypeRules(f.Type, fp, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitRules splits a validate tag into name/argument pairs. The pattern rule
+// takes the rest of the tag, commas included.
+func splitRules(tag string) [][2]string {
+	rules := make([][2]string, 0)
+	for tag != "" {
+		rule := tag
+		if strings.HasPrefix(tag, "pattern=") {
+			tag = ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			tag = ""
+		}
+
+		if i := strings.Index(rule, "="); i >= 0 {
+			rules = append(rules, [2]string{rule[:i], rule[i+1:]})
+		} else {
+			rules = append(rules, [2]string{rule, ""})
+		}
+	}
+	return rules
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	}
+	return 0, false
+}
+
+func compilePattern(p string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(p); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern '%v': %v", p, err)
+	}
+	patterns.Store(p, re)
+	return re, nil
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if tag == "" {
+		return f.Name
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}